@@ -0,0 +1,202 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"sync"
+)
+
+// maxAppendBatch is the maximum number of messages an Appender writes together
+const maxAppendBatch = 64
+
+// AppenderClosed is returned by Append after the Appender was closed
+var AppenderClosed = errors.New("appender is closed")
+
+// AppendResult is the result of appending a message with an Appender
+type AppendResult struct {
+	// Offset is the offset of the message in the mailbox
+	Offset int64
+	// Length is the length of the message in the mailbox, including the blank line after it
+	Length int64
+	// Err is not nil if the message was not appended
+	Err error
+}
+
+// Appender appends messages from many goroutines to a mailbox. The messages are encoded one at a time by a
+// single goroutine, and the messages that are waiting are written together, with a single write.
+// If the underlying writer is a file, a write that fails is truncated, and with WithSync the file is synced
+// after each write
+type Appender struct {
+	w io.Writer
+	// enc encodes the messages to b, base is the offset of the mailbox when enc began
+	enc  *MailboxWriter
+	b    *batch
+	base int64
+
+	requests chan *appendRequest
+	stopped  chan struct{}
+	// mu guards closed, it's held to send to requests
+	mu     sync.RWMutex
+	closed bool
+
+	config
+}
+
+// appendRequest is a message waiting to be appended
+type appendRequest struct {
+	e      Envelope
+	msg    []byte
+	result chan AppendResult
+}
+
+// batch holds the encoded messages until they're written together
+type batch struct {
+	bytes.Buffer
+	w io.Writer
+}
+
+// Stat returns the FileInfo of the mailbox, Babyl uses it to tell if it's empty
+func (b *batch) Stat() (fs.FileInfo, error) {
+	return stat(b.w)
+}
+
+// NewAppender returns an Appender, ready to append messages to w.
+// Use WithVariant to write formats other than mboxrd, including MMDF and Babyl
+func NewAppender(w io.Writer, opts ...Option) *Appender {
+	a := &Appender{
+		w:        w,
+		b:        &batch{w: w},
+		requests: make(chan *appendRequest),
+		stopped:  make(chan struct{}),
+		config:   newConfig(opts),
+	}
+	a.enc = NewMailboxWriter(a.b, opts...)
+	if fi, err := stat(w); err == nil {
+		a.base = fi.Size()
+	}
+	go a.run()
+	return a
+}
+
+// OpenAppender opens the mailbox at path with AppendFile, and returns an Appender for it.
+// The locks are held until the Appender is closed
+func OpenAppender(path string, opts ...Option) (*Appender, error) {
+	f, err := appendFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewAppender(f, opts...), nil
+}
+
+// Append appends a message with the envelope e, and returns when it was written.
+// msg must not be changed until Append returns. It's safe to call Append from many goroutines
+func (a *Appender) Append(e Envelope, msg []byte) AppendResult {
+	req := &appendRequest{e: e, msg: msg, result: make(chan AppendResult, 1)}
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		return AppendResult{Err: AppenderClosed}
+	}
+	a.requests <- req
+	a.mu.RUnlock()
+	return <-req.result
+}
+
+// Close waits for the messages being appended, and closes the underlying writer if it's an io.Closer
+func (a *Appender) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return AppenderClosed
+	}
+	a.closed = true
+	close(a.requests)
+	a.mu.Unlock()
+	<-a.stopped
+	if closer, ok := a.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// run writes the requests in batches, until requests is closed
+func (a *Appender) run() {
+	defer close(a.stopped)
+	reqs := make([]*appendRequest, 0, maxAppendBatch)
+	for req := range a.requests {
+		reqs = append(reqs[:0], req)
+	more:
+		for len(reqs) < maxAppendBatch {
+			select {
+			case req, ok := <-a.requests:
+				if !ok {
+					break more
+				}
+				reqs = append(reqs, req)
+			default:
+				break more
+			}
+		}
+		a.write(reqs)
+	}
+}
+
+// write encodes reqs, writes them together, and sends the results
+func (a *Appender) write(reqs []*appendRequest) {
+	results := make([]AppendResult, len(reqs))
+	start := a.enc.offset()
+	a.b.Reset()
+	for i, req := range reqs {
+		results[i] = a.encode(req)
+	}
+	if err := a.flush(a.base + start); err != nil {
+		// the batch is not in the mailbox, the next one is written where it began
+		a.base -= a.enc.offset() - start
+		for i := range results {
+			if results[i].Err == nil {
+				results[i] = AppendResult{Err: err}
+			}
+		}
+	}
+	for i, req := range reqs {
+		req.result <- results[i]
+	}
+}
+
+// encode encodes the message of req to the batch
+func (a *Appender) encode(req *appendRequest) AppendResult {
+	n := a.b.Len()
+	err := a.enc.BeginMessage(req.e)
+	offset := a.enc.Envelope().Offset
+	if err == nil {
+		_, err = a.enc.Write(req.msg)
+	}
+	if err == nil {
+		err = a.enc.EndMessage()
+	}
+	if err != nil {
+		// the encoder was rolled back to where the message began
+		a.b.Truncate(n)
+		return AppendResult{Err: err}
+	}
+	return AppendResult{Offset: a.base + offset, Length: a.enc.offset() - offset}
+}
+
+// flush writes the batch, and syncs the file. If that fails, the file is truncated back to size
+func (a *Appender) flush(size int64) error {
+	if a.b.Len() == 0 {
+		return nil
+	}
+	_, err := a.w.Write(a.b.Bytes())
+	if s, ok := a.w.(interface{ Sync() error }); ok && err == nil && a.sync {
+		err = s.Sync()
+	}
+	if f, ok := a.w.(truncater); ok && err != nil {
+		if terr := f.Truncate(size); terr == nil {
+			_, _ = f.Seek(size, io.SeekStart)
+		}
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package mbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAppender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	const first = "From a@example.com Wed Jan 27 02:32:22 2021\nSubject: first\n\nfirst\n\n"
+	if err := os.WriteFile(path, []byte(first), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := OpenAppender(path, WithSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Envelope{From: "a@example.com", Date: time.Date(2021, 1, 27, 2, 32, 22, 0, time.UTC)}
+	const workers, messages = 20, 10
+	results := make([]AppendResult, workers*messages)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range messages {
+				body := fmt.Sprintf("Subject: %d-%d\n\nFrom %d\n", w, m, m)
+				results[w*messages+m] = a.Append(e, []byte(body))
+			}
+		}()
+	}
+	wg.Wait()
+	if err = a.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = a.Close(); !errors.Is(err, AppenderClosed) {
+		t.Error("expecting AppenderClosed, got", err)
+	}
+	if r := a.Append(e, []byte("Subject: late\n\nlate\n")); !errors.Is(r.Err, AppenderClosed) {
+		t.Error("expecting AppenderClosed, got", r.Err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(first))
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		msg := string(data[r.Offset : r.Offset+r.Length])
+		expected := fmt.Sprintf("Subject: %d-%d\n\n>From %d\n\n", i/messages, i%messages, i%messages)
+		if !strings.HasPrefix(msg, "From a@example.com ") || !strings.HasSuffix(msg, expected) {
+			t.Errorf("unexpected message %q at offset %d", msg, r.Offset)
+		}
+		size += r.Length
+	}
+	if size != int64(len(data)) {
+		t.Errorf("the messages are %d bytes, the mailbox is %d", size, len(data))
+	}
+	if bodies := readBodies(t, string(data)); len(bodies) != workers*messages+1 {
+		t.Error("unexpected number of messages", len(bodies))
+	}
+}
+
+func TestAppenderRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAppender(&failingFile{File: f, fail: 100})
+	e := Envelope{From: "a@example.com", Date: time.Date(2021, 1, 27, 2, 32, 22, 0, time.UTC)}
+	var results []AppendResult
+	for _, body := range []string{"Subject: one\n\none\n", "Subject: two\n\n" + strings.Repeat("two\n", 20), "Subject: three\n\nthree\n"} {
+		results = append(results, a.Append(e, []byte(body)))
+	}
+	if err = a.Close(); err != nil {
+		t.Error(err)
+	}
+	if !errors.Is(results[1].Err, errWrite) {
+		t.Error("expecting the write error, got", results[1].Err)
+	}
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Error(results[0].Err, results[2].Err)
+	}
+	if results[2].Offset != results[0].Length {
+		t.Error("unexpected offset", results[2].Offset)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodies := readBodies(t, string(data)); !slices.Equal(bodies, []string{"Subject: one\n\none\n", "Subject: three\n\nthree\n"}) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+}
+
+func TestAppenderBabyl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "babyl")
+	e := Envelope{From: "a@example.com", Date: time.Date(2021, 1, 27, 2, 32, 22, 0, time.UTC)}
+	for _, body := range []string{"Subject: one\n\none\n", "Subject: two\n\ntwo\n"} {
+		a, err := OpenAppender(path, WithVariant(Babyl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := a.Append(e, []byte(body)); r.Err != nil {
+			t.Error(r.Err)
+		}
+		if err = a.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the preamble is written only to an empty file
+	if n := strings.Count(string(data), "BABYL OPTIONS:"); n != 1 {
+		t.Errorf("expecting one preamble, got %d in %q", n, data)
+	}
+	s := NewScanner(strings.NewReader(string(data)), WithVariant(Babyl))
+	n := 0
+	for s.Next() {
+		n++
+	}
+	if s.Err() != nil || n != 2 {
+		t.Error("unexpected messages", n, s.Err())
+	}
+}
@@ -0,0 +1,400 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+const (
+	// babylOptions starts a Babyl file
+	babylOptions = "BABYL OPTIONS:"
+	// babylPreamble is written to the start of new Babyl files
+	babylPreamble = babylOptions + " -*- rmail -*-\nVersion: 5\nLabels:\nNote:   This is the header of an rmail file.\n" +
+		"Note:   If you are seeing it in rmail,\nNote:    it means the file has no messages in it.\n"
+	// babylEnd ends the preamble and each message
+	babylEnd = '\x1f'
+	// babylStart starts each message, it follows babylEnd
+	babylStart = '\x0c'
+	// babylEOOH is the end of the original header, the visible header follows
+	babylEOOH = "*** EOOH ***\n"
+)
+
+// BabylInfo holds the attributes and labels of a Babyl message
+type BabylInfo struct {
+	// Reformed is true if the message has a visible header that differs from the original header
+	Reformed bool
+	// Attributes are the built-in attributes, such as "unseen", "deleted" and "answered"
+	Attributes []string
+	// Labels are the user defined labels
+	Labels []string
+	// Visible is the visible header when Reformed, the original header is part of the message
+	Visible []byte
+}
+
+// Has returns true if the message has the attribute
+func (b *BabylInfo) Has(attribute string) bool {
+	for i := range b.Attributes {
+		if b.Attributes[i] == attribute {
+			return true
+		}
+	}
+	return false
+}
+
+// Deleted returns true if the message is marked as deleted
+func (b *BabylInfo) Deleted() bool {
+	return b.Has("deleted")
+}
+
+// Unseen returns true if the message was not seen
+func (b *BabylInfo) Unseen() bool {
+	return b.Has("unseen")
+}
+
+// Answered returns true if the message was answered
+func (b *BabylInfo) Answered() bool {
+	return b.Has("answered")
+}
+
+// parseBabylAttributes parses the line that follows the message start, eg. "0, unseen, answered,, label1,"
+func parseBabylAttributes(line string) (*BabylInfo, error) {
+	line = strings.TrimSuffix(line, string(newLine))
+	attrs, labels, found := strings.Cut(line, ",,")
+	if !found {
+		return nil, InvalidFormat
+	}
+	b := new(BabylInfo)
+	fields := strings.Split(attrs, ",")
+	switch strings.TrimSpace(fields[0]) {
+	case "0":
+	case "1":
+		b.Reformed = true
+	default:
+		return nil, InvalidFormat
+	}
+	for _, f := range fields[1:] {
+		if f = strings.TrimSpace(f); f != "" {
+			b.Attributes = append(b.Attributes, f)
+		}
+	}
+	for _, f := range strings.Split(labels, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			b.Labels = append(b.Labels, f)
+		}
+	}
+	return b, nil
+}
+
+// String returns the attribute line
+func (b *BabylInfo) String() string {
+	var sb strings.Builder
+	if b.Reformed {
+		sb.WriteString("1,")
+	} else {
+		sb.WriteString("0,")
+	}
+	for _, a := range b.Attributes {
+		sb.WriteString(" ")
+		sb.WriteString(a)
+		sb.WriteString(",")
+	}
+	sb.WriteString(",")
+	for _, l := range b.Labels {
+		sb.WriteString(" ")
+		sb.WriteString(l)
+		sb.WriteString(",")
+	}
+	return sb.String()
+}
+
+// babylDecoder reads Babyl (Emacs RMAIL) files.
+// For reformed messages, the original header is returned with the body, and the visible header is
+// available from the envelope
+type babylDecoder struct {
+	r *bufio.Reader
+	// pending is what's left to output before the next line
+	pending []byte
+	// original is the original header of a reformed message
+	original []byte
+	// started is true after the preamble was read
+	started bool
+	// more is true if another message follows
+	more bool
+	// inMessage is true while reading a message
+	inMessage bool
+	// lineStart is true if the next byte is at the start of a line
+	lineStart bool
+	// pos is the offset of the next byte from r
+	pos int64
+	// start is the offset of the current message's start, and nextStart of the next one
+	start     int64
+	nextStart int64
+	info      *BabylInfo
+	// last is the offset of the last line read, and lastLine its line number
+	last     int64
+	lastLine int64
+	// lines is the number of lines read, and messages the number of messages started
+	lines    int64
+	messages int
+
+	config
+}
+
+// NewBabylReader returns an io.Reader, ready to decode Babyl streams.
+// Like NewReader, io.EOF is returned at the end of each message, so the reader can be used again to
+// read the next message
+func NewBabylReader(r io.Reader, opts ...Option) *babylDecoder {
+	d := new(babylDecoder)
+	d.r = bufio.NewReaderSize(r, defaultBufferSize)
+	d.config = newConfig(opts)
+	return d
+}
+
+// readLine reads a line, which is only valid until the next read.
+// Lines longer than the buffer are returned in parts
+func (r *babylDecoder) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice(newLine)
+	r.last, r.lastLine = r.pos, r.lines+1
+	r.pos += int64(len(line))
+	if len(line) > 0 && line[len(line)-1] == newLine {
+		r.lines++
+	}
+	if (err == io.EOF && len(line) > 0) || err == bufio.ErrBufferFull {
+		err = nil
+	}
+	return line, err
+}
+
+// end is called with a line that starts with babylEnd, the message start may follow
+func (r *babylDecoder) end(line []byte) {
+	r.more = len(line) > 1 && line[1] == babylStart
+	r.nextStart = r.pos - int64(len(line)) + 1
+}
+
+// parseError returns InvalidFormat as a ParseError, at the last line read
+func (r *babylDecoder) parseError() error {
+	return &ParseError{Err: InvalidFormat, Offset: r.last, Line: r.lastLine, Message: max(r.messages-1, 0)}
+}
+
+// next reads up to the start of the next message. io.EOF is returned if there are no more messages
+func (r *babylDecoder) next() error {
+	if r.inMessage {
+		return r.parseError()
+	}
+	if !r.started {
+		line, err := r.readLine()
+		if err == io.EOF {
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+		if !bytes.HasPrefix(line, []byte(babylOptions)) {
+			return r.parseError()
+		}
+		for !r.started {
+			if line, err = r.readLine(); err == io.EOF {
+				return r.parseError()
+			} else if err != nil {
+				return err
+			}
+			if len(line) > 0 && line[0] == babylEnd {
+				r.end(line)
+				r.started = true
+			}
+		}
+	}
+	if !r.more {
+		return io.EOF
+	}
+	r.start = r.nextStart
+	r.messages++
+	line, err := r.readLine()
+	if err != nil {
+		return r.parseError()
+	}
+	if r.info, err = parseBabylAttributes(string(line)); err != nil {
+		return r.parseError()
+	}
+	r.original = r.original[:0]
+	if r.info.Reformed {
+		// original header, up to the EOOH line, then the visible header
+		for {
+			if line, err = r.readLine(); err != nil {
+				return r.parseError()
+			}
+			if string(line) == babylEOOH {
+				break
+			}
+			r.original = append(r.original, line...)
+		}
+		if !bytes.HasSuffix(r.original, []byte{newLine, newLine}) {
+			r.original = append(r.original, newLine)
+		}
+		for {
+			if line, err = r.readLine(); err != nil {
+				return r.parseError()
+			}
+			if line[0] == newLine {
+				break
+			}
+			r.info.Visible = append(r.info.Visible, line...)
+		}
+	} else if line, err = r.readLine(); err != nil || string(line) != babylEOOH {
+		return r.parseError()
+	}
+	r.pending = r.original
+	r.inMessage = true
+	r.lineStart = true
+	return nil
+}
+
+// Read implements io.Reader
+func (r *babylDecoder) Read(p []byte) (int, error) {
+	if !r.inMessage {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for n < len(p) {
+		if len(r.pending) > 0 {
+			c := copy(p[n:], r.pending)
+			r.pending = r.pending[c:]
+			n += c
+			continue
+		}
+		line, err := r.readLine()
+		if err == io.EOF {
+			// the end of the message is missing
+			return n, r.parseError()
+		} else if err != nil {
+			return n, err
+		}
+		if r.lineStart && line[0] == babylEnd {
+			r.end(line)
+			r.inMessage = false
+			return n, io.EOF
+		}
+		r.lineStart = line[len(line)-1] == newLine
+		r.pending = line
+	}
+	return n, nil
+}
+
+// Envelope returns the envelope of the current message, with the offset of the message start and
+// the attributes
+func (r *babylDecoder) Envelope() (Envelope, error) {
+	return Envelope{Offset: r.start, Babyl: r.info}, nil
+}
+
+// Close resets all state
+func (r *babylDecoder) Close() error {
+	r.pending = nil
+	r.inMessage = false
+	return nil
+}
+
+// babylEncoder writes Babyl files
+type babylEncoder struct {
+	c   counter
+	env Envelope
+	// last is the last byte written
+	last byte
+
+	config
+}
+
+// NewBabylWriter returns an io.Writer, ready to encode messages to Babyl streams.
+// The preamble is written before the first message if w is empty.
+// The attributes and labels are taken from the Envelope, a message without them is marked as unseen.
+// A message must not contain a line starting with Ctrl-_, since Babyl has no escaping
+func NewBabylWriter(w io.Writer, opts ...Option) *babylEncoder {
+	e := new(babylEncoder)
+	e.c.w = w
+	e.config = newConfig(opts)
+	return e
+}
+
+// empty returns true if nothing was written to the underlying writer yet
+func (w *babylEncoder) empty() bool {
+	if w.c.n > 0 {
+		return false
+	}
+	if f, ok := w.c.w.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		if fi, err := f.Stat(); err == nil {
+			return fi.Size() == 0
+		}
+	}
+	return true
+}
+
+// Open begins a new message. from and t are not part of Babyl, but are kept for Envelope
+func (w *babylEncoder) Open(from string, t time.Time) error {
+	return w.OpenEnvelope(Envelope{From: from, Date: t})
+}
+
+// OpenEnvelope begins a new message with the attributes and labels of e.Babyl.
+// The message is always written with its header visible, so e.Babyl.Reformed and e.Babyl.Visible are not used
+func (w *babylEncoder) OpenEnvelope(e Envelope) error {
+	var sb strings.Builder
+	if w.empty() {
+		sb.WriteString(babylPreamble)
+		sb.WriteByte(babylEnd)
+	}
+	w.env = e
+	w.env.Offset = w.c.n + int64(sb.Len())
+	info := BabylInfo{Attributes: []string{"unseen"}}
+	if e.Babyl != nil {
+		info = BabylInfo{Attributes: e.Babyl.Attributes, Labels: e.Babyl.Labels}
+	}
+	sb.WriteByte(babylStart)
+	sb.WriteByte(newLine)
+	sb.WriteString(info.String())
+	sb.WriteByte(newLine)
+	sb.WriteString(babylEOOH)
+	w.last = newLine
+	_, err := io.Copy(&w.c, strings.NewReader(sb.String()))
+	return err
+}
+
+// Envelope returns the envelope of the current message
+func (w *babylEncoder) Envelope() Envelope {
+	return w.env
+}
+
+// Write implements io.Writer, p is written as-is
+func (w *babylEncoder) Write(p []byte) (int, error) {
+	n64, err := io.Copy(&w.c, bytes.NewReader(p))
+	if n64 > 0 {
+		w.last = p[n64-1]
+	}
+	return int(n64), err
+}
+
+// offset returns the number of bytes written to the underlying writer
+func (w *babylEncoder) offset() int64 {
+	return w.c.n
+}
+
+// rollback abandons the current message, the underlying writer was truncated to n bytes
+func (w *babylEncoder) rollback(n int64) {
+	w.c.n = n
+}
+
+// Close ends the message, and closes the underlying writer if it's an io.Closer
+func (w *babylEncoder) Close() error {
+	end := []byte{babylEnd}
+	if w.last != newLine {
+		end = []byte{newLine, babylEnd}
+	}
+	_, err := io.Copy(&w.c, bytes.NewReader(end))
+	if closer, ok := w.c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const babylTest1 = "BABYL OPTIONS: -*- rmail -*-\nVersion: 5\nLabels: work\nNote:   This is the header of an rmail file.\n\x1f" +
+	"\x0c\n0, unseen,,\n*** EOOH ***\nSubject: one\n\nbody one\n\x1f" +
+	"\x0c\n1, answered, deleted,, work, todo,\nReceived: by example.com\nSubject: two\n\n*** EOOH ***\nSubject: two\n\nbody two\n\x1f"
+
+func TestBabylRead(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte(babylTest1)), WithVariant(Babyl))
+	var bodies []string
+	var envelopes []Envelope
+	for s.Next() {
+		body, err := io.ReadAll(s.Message().Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, string(body))
+		envelopes = append(envelopes, s.Message().Envelope)
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	if len(bodies) != 2 {
+		t.Fatal("expecting 2 messages, got", len(bodies))
+	}
+	if bodies[0] != "Subject: one\n\nbody one\n" {
+		t.Error("unexpected body", bodies[0])
+	}
+	if bodies[1] != "Received: by example.com\nSubject: two\n\nbody two\n" {
+		t.Error("unexpected body", bodies[1])
+	}
+	b := envelopes[0].Babyl
+	if b == nil || !b.Unseen() || b.Answered() || b.Reformed || len(b.Labels) != 0 {
+		t.Error("unexpected attributes", b)
+	}
+	if envelopes[0].Offset != 99 {
+		t.Error("expecting offset 99, got", envelopes[0].Offset)
+	}
+	b = envelopes[1].Babyl
+	if b == nil || b.Unseen() || !b.Answered() || !b.Deleted() || !b.Reformed {
+		t.Error("unexpected attributes", b)
+	}
+	if len(b.Labels) != 2 || b.Labels[0] != "work" || b.Labels[1] != "todo" {
+		t.Error("unexpected labels", b.Labels)
+	}
+	if string(b.Visible) != "Subject: two\n" {
+		t.Error("unexpected visible header", string(b.Visible))
+	}
+}
+
+func TestBabylReadBad(t *testing.T) {
+	for _, test := range []struct {
+		in           string
+		offset, line int64
+	}{
+		{"garbage\n", 0, 1},
+		{"BABYL OPTIONS:\n", 15, 2},
+		{"BABYL OPTIONS:\n\x1f\x0c\nno attributes\n", 18, 3},
+		{"BABYL OPTIONS:\n\x1f\x0c\n0, unseen,,\nno eooh\n", 30, 4},
+		{"BABYL OPTIONS:\n\x1f\x0c\n0, unseen,,\n*** EOOH ***\nno end\n", 50, 6},
+	} {
+		s := NewScanner(bytes.NewReader([]byte(test.in)), WithVariant(Babyl))
+		for s.Next() {
+		}
+		var pe *ParseError
+		if !errors.As(s.Err(), &pe) || !errors.Is(pe, InvalidFormat) {
+			t.Errorf("%q expecting InvalidFormat, got %v", test.in, s.Err())
+		} else if pe.Offset != test.offset || pe.Line != test.line || pe.Message != 0 {
+			t.Errorf("%q unexpected error %v", test.in, pe)
+		}
+	}
+	// no messages
+	s := NewScanner(bytes.NewReader([]byte("BABYL OPTIONS:\n\x1f")), WithVariant(Babyl))
+	if s.Next() || s.Err() != nil {
+		t.Error("expecting no messages")
+	}
+}
+
+func TestBabylWrite(t *testing.T) {
+	var b bytes.Buffer
+	w := NewBabylWriter(struct{ io.Writer }{&b})
+	if err := w.Open("test@example.com", time.Now()); err != nil {
+		t.Error(err)
+	}
+	_, _ = w.Write([]byte("Subject: one\n\nbody one\n"))
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+	e := Envelope{Babyl: &BabylInfo{Attributes: []string{"answered"}, Labels: []string{"work"}}}
+	if err := w.OpenEnvelope(e); err != nil {
+		t.Error(err)
+	}
+	_, _ = w.Write([]byte("Subject: two\n\nno newline"))
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+	expected := babylPreamble + "\x1f\x0c\n0, unseen,,\n*** EOOH ***\nSubject: one\n\nbody one\n\x1f" +
+		"\x0c\n0, answered,, work,\n*** EOOH ***\nSubject: two\n\nno newline\n\x1f"
+	if b.String() != expected {
+		t.Errorf("unexpected result %q", b.String())
+	}
+
+	s := NewScanner(&b, WithVariant(Babyl))
+	count := 0
+	for m, err := range s.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		if count == 2 && (!m.Envelope.Babyl.Answered() || m.Envelope.Babyl.Labels[0] != "work") {
+			t.Error("unexpected attributes", m.Envelope.Babyl)
+		}
+	}
+	if count != 2 {
+		t.Error("expecting 2 messages, got", count)
+	}
+}
+
+// the preamble should only be written to empty files
+func TestBabylWriteAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "RMAIL")
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewBabylWriter(f)
+		_ = w.Open("test@example.com", time.Now())
+		_, _ = w.Write([]byte("Subject: test\n\nbody\n"))
+		if err = w.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Count(data, []byte(babylOptions)) != 1 {
+		t.Error("expecting a single preamble")
+	}
+	s := NewScanner(bytes.NewReader(data), WithVariant(Babyl))
+	count := 0
+	for s.Next() {
+		count++
+	}
+	if s.Err() != nil || count != 2 {
+		t.Error("expecting 2 messages", count, s.Err())
+	}
+}
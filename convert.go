@@ -0,0 +1,186 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// maxLinePrefix is how much of the start of each line is checked for ambiguities
+const maxLinePrefix = 64
+
+// Converter converts a mailbox from one variant to another, in a single pass
+type Converter struct {
+	// From is the variant to read
+	From Variant
+	// To is the variant to write
+	To Variant
+	// Options are passed to the reader and writer, eg. WithDateLayouts
+	Options []Option
+	// Ambiguous, if not nil, is called for each message that was ambiguous in the source, or would be
+	// ambiguous in the target
+	Ambiguous func(a Ambiguity)
+}
+
+// Ambiguity describes a message that may not be converted losslessly
+type Ambiguity struct {
+	// Index is the index of the message, starting at 0
+	Index int
+	// Envelope is the envelope of the message in the source
+	Envelope Envelope
+	// Line is the line number of the first ambiguous line in the message, starting at 1.
+	// It's 0 if the ambiguity is in the envelope
+	Line int
+	// Reason describes the ambiguity
+	Reason string
+}
+
+// quotesFrom returns true if the variant leaves ">From " lines as they are, so they cannot be told apart
+// from escaped "From " lines
+func quotesFrom(v Variant) bool {
+	return v == MboxO || v == MboxCL
+}
+
+// hasEnvelope returns true if the variant has a "From " line
+func hasEnvelope(v Variant) bool {
+	return v != MMDF && v != Babyl
+}
+
+// check returns the reason a line of a decoded message is ambiguous, or an empty string.
+// line is the start of the line, up to maxLinePrefix bytes
+func (c *Converter) check(line []byte) string {
+	if quotesFrom(c.From) != quotesFrom(c.To) && escapedFrom(line) > 0 {
+		if quotesFrom(c.From) {
+			return "\">From \" line could be an escaped \"From \" line in " + c.From.String()
+		}
+		return "\">From \" line cannot be told apart from an escaped \"From \" line in " + c.To.String()
+	}
+	switch {
+	case c.To == MMDF && isMMDFDelimiter(line):
+		return "line of four Ctrl-A characters would end the message in mmdf"
+	case c.To == Babyl && len(line) > 0 && line[0] == babylEnd:
+		return "line starting with Ctrl-_ would end the message in babyl"
+	}
+	return ""
+}
+
+// checkEnvelope returns the reason the envelope of a message can't be converted, or an empty string.
+// The Babyl writer always writes the header visible, and the other variants have no attributes
+func (c *Converter) checkEnvelope(e Envelope) string {
+	b := e.Babyl
+	switch {
+	case b == nil:
+	case b.Reformed:
+		return "the visible header of a reformed message is not kept in " + c.To.String()
+	case c.To != Babyl && (len(b.Labels) > 0 || len(b.Attributes) > 1 || (len(b.Attributes) == 1 && !b.Has("unseen"))):
+		return "babyl attributes and labels are not kept in " + c.To.String()
+	}
+	return ""
+}
+
+// Convert reads the messages from src and writes them to dst, returning the number of messages converted.
+// dst is not closed.
+// Messages from formats without a "From " line get one from HeaderEnvelope
+func (c *Converter) Convert(dst io.Writer, src io.Reader) (int, error) {
+	s := NewScanner(src, append(c.Options[:len(c.Options):len(c.Options)], WithVariant(c.From))...)
+	w := NewMailboxWriter(dst, append(c.Options[:len(c.Options):len(c.Options)], WithVariant(c.To))...)
+	buf := make([]byte, defaultBufferSize)
+	n := 0
+	for s.Next() {
+		m := s.Message()
+		e := m.Envelope
+		var body io.Reader = m.Body
+		watch := &lineWatcher{r: body, check: c.check}
+		if c.Ambiguous != nil {
+			body = watch
+		}
+		if hasEnvelope(c.To) && e.Raw == "" {
+			br := bufio.NewReader(body)
+			hdr, err := peekHeaders(br)
+			if err != nil {
+				return n, err
+			}
+			h := HeaderEnvelope(hdr)
+			e.From, e.Date = h.From, h.Date
+			body = br
+		}
+		if err := w.BeginMessage(e); err != nil {
+			return n, err
+		}
+		if _, err := io.CopyBuffer(w, body, buf); err != nil {
+			return n, err
+		}
+		if err := w.EndMessage(); err != nil {
+			return n, err
+		}
+		if c.Ambiguous != nil {
+			if reason := c.checkEnvelope(m.Envelope); reason != "" {
+				c.Ambiguous(Ambiguity{Index: n, Envelope: m.Envelope, Reason: reason})
+			} else if watch.reason != "" {
+				c.Ambiguous(Ambiguity{Index: n, Envelope: m.Envelope, Line: watch.at, Reason: watch.reason})
+			}
+		}
+		n++
+	}
+	return n, s.Err()
+}
+
+// peekHeaders returns the header block of the message, without reading it from br
+func peekHeaders(br *bufio.Reader) ([]byte, error) {
+	for size := 512; ; size *= 2 {
+		hdr, err := br.Peek(size)
+		if i := bytes.Index(hdr, []byte{newLine, newLine}); i != -1 {
+			return hdr[:i+1], nil
+		}
+		if err != nil {
+			if err == io.EOF || err == bufio.ErrBufferFull {
+				// no body, or too long to look at
+				return hdr, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// lineWatcher reads from r, and calls check with the start of each line.
+// The first reason returned by check, and its line number are kept
+type lineWatcher struct {
+	r     io.Reader
+	check func(line []byte) string
+	// line is the start of the current line
+	line   []byte
+	n      int
+	reason string
+	at     int
+}
+
+// Read implements io.Reader
+func (w *lineWatcher) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	if w.reason != "" {
+		return n, err
+	}
+	for _, b := range p[:n] {
+		if len(w.line) < maxLinePrefix {
+			w.line = append(w.line, b)
+		}
+		if b == newLine {
+			w.endLine()
+		}
+	}
+	if err == io.EOF && len(w.line) > 0 {
+		w.endLine()
+	}
+	return n, err
+}
+
+// endLine checks the current line
+func (w *lineWatcher) endLine() {
+	w.n++
+	if w.reason == "" {
+		if w.reason = w.check(w.line); w.reason != "" {
+			w.at = w.n
+		}
+	}
+	w.line = w.line[:0]
+}
@@ -0,0 +1,157 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+const convertTest1 = `From test@example.com Wed Jan 27 02:32:22 2021
+Subject: one
+
+>From the start
+>>From quoted
+
+From test2@example.com Wed Jan 27 02:32:23 2021
+Subject: two
+
+nothing to see
+
+`
+
+func TestConvertMboxOToMboxRD(t *testing.T) {
+	var ambiguous []Ambiguity
+	c := Converter{From: MboxO, To: MboxRD, Ambiguous: func(a Ambiguity) {
+		ambiguous = append(ambiguous, a)
+	}}
+	var b bytes.Buffer
+	n, err := c.Convert(&b, strings.NewReader(convertTest1))
+	if err != nil {
+		t.Error(err)
+	}
+	if n != 2 {
+		t.Error("expecting 2 messages, got", n)
+	}
+	// mboxo did not unescape, so mboxrd escapes once more
+	expected := strings.Replace(convertTest1, ">From the start\n>>From quoted", ">>From the start\n>>>From quoted", 1)
+	if b.String() != expected {
+		t.Error("unexpected result", b.String())
+	}
+	if len(ambiguous) != 1 {
+		t.Fatal("expecting 1 ambiguous message, got", len(ambiguous))
+	}
+	if ambiguous[0].Index != 0 || ambiguous[0].Line != 3 || ambiguous[0].Envelope.From != "test@example.com" {
+		t.Error("unexpected ambiguity", ambiguous[0])
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	for _, v := range []Variant{MboxCL, MboxCL2, MMDF, Babyl} {
+		var b, back bytes.Buffer
+		c := Converter{From: MboxRD, To: v}
+		if _, err := c.Convert(&b, strings.NewReader(convertTest1)); err != nil {
+			t.Error(v, err)
+		}
+		c = Converter{From: v, To: MboxRD}
+		n, err := c.Convert(&back, &b)
+		if err != nil {
+			t.Error(v, err)
+		}
+		if n != 2 {
+			t.Error(v, "expecting 2 messages, got", n)
+		}
+		result := back.String()
+		expected := convertTest1
+		switch v {
+		case MboxCL:
+			// mboxcl quotes like mboxo, so the two lines can no longer be told apart
+			expected = strings.Replace(expected, ">From the start\n>>From quoted", ">>From the start\n>>From quoted", 1)
+			expected = strings.Replace(expected, "Subject: one\n", "Subject: one\nContent-Length: 29\n", 1)
+			expected = strings.Replace(expected, "Subject: two\n", "Subject: two\nContent-Length: 15\n", 1)
+		case MboxCL2:
+			expected = strings.Replace(expected, "Subject: one\n", "Subject: one\nContent-Length: 28\n", 1)
+			expected = strings.Replace(expected, "Subject: two\n", "Subject: two\nContent-Length: 15\n", 1)
+		}
+		if v == MMDF || v == Babyl {
+			// the "From " lines are lost
+			if !strings.HasPrefix(result, "From MAILER-DAEMON Mon Jan  1 00:00:00 0001\n") {
+				t.Error(v, "unexpected result", result)
+			}
+			continue
+		}
+		if result != expected {
+			t.Error(v, "unexpected result", result)
+		}
+	}
+}
+
+func TestConvertEnvelopeFromHeaders(t *testing.T) {
+	const in = "\x01\x01\x01\x01\nReturn-Path: <test@example.com>\nDate: Wed, 27 Jan 2021 02:32:22 -0800\n\nbody\n\x01\x01\x01\x01\n"
+	var b bytes.Buffer
+	c := Converter{From: MMDF, To: MboxRD}
+	if _, err := c.Convert(&b, strings.NewReader(in)); err != nil {
+		t.Error(err)
+	}
+	expected := "From test@example.com Wed Jan 27 10:32:22 2021\nReturn-Path: <test@example.com>\n" +
+		"Date: Wed, 27 Jan 2021 02:32:22 -0800\n\nbody\n\n"
+	if b.String() != expected {
+		t.Error("unexpected result", b.String())
+	}
+}
+
+func TestConvertAmbiguousTarget(t *testing.T) {
+	tests := []struct {
+		to   Variant
+		body string
+	}{
+		{MboxO, "Subject: test\n\n>From quoted\n"},
+		{MMDF, "Subject: test\n\n\x01\x01\x01\x01\n"},
+		{Babyl, "Subject: test\n\n\x1fend\n"},
+	}
+	for _, test := range tests {
+		in := "From test@example.com Wed Jan 27 02:32:22 2021\n" + strings.ReplaceAll(test.body, ">From", ">>From") + "\n"
+		var ambiguous []Ambiguity
+		c := Converter{From: MboxRD, To: test.to, Ambiguous: func(a Ambiguity) {
+			ambiguous = append(ambiguous, a)
+		}}
+		var b bytes.Buffer
+		if _, err := c.Convert(&b, strings.NewReader(in)); err != nil {
+			t.Error(test.to, err)
+		}
+		if len(ambiguous) != 1 || ambiguous[0].Line != 3 {
+			t.Error(test.to, "expecting an ambiguity on line 3, got", ambiguous)
+		}
+	}
+}
+
+// the attributes of Babyl messages are only kept in Babyl, and reformed messages lose their visible header
+func TestConvertAmbiguousBabyl(t *testing.T) {
+	for _, to := range []Variant{MboxRD, Babyl} {
+		var ambiguous []Ambiguity
+		c := Converter{From: Babyl, To: to, Ambiguous: func(a Ambiguity) {
+			ambiguous = append(ambiguous, a)
+		}}
+		var b bytes.Buffer
+		if _, err := c.Convert(&b, strings.NewReader(babylTest1)); err != nil {
+			t.Error(to, err)
+		}
+		if len(ambiguous) != 1 || ambiguous[0].Index != 1 || ambiguous[0].Line != 0 {
+			t.Error(to, "expecting an ambiguity in the second envelope, got", ambiguous)
+		}
+	}
+
+	const labelled = "BABYL OPTIONS:\n\x1f\x0c\n0, answered,, work,\n*** EOOH ***\nSubject: one\n\nbody\n\x1f"
+	for to, expected := range map[Variant]int{MboxRD: 1, Babyl: 0} {
+		var ambiguous []Ambiguity
+		c := Converter{From: Babyl, To: to, Ambiguous: func(a Ambiguity) {
+			ambiguous = append(ambiguous, a)
+		}}
+		if _, err := c.Convert(io.Discard, strings.NewReader(labelled)); err != nil {
+			t.Error(to, err)
+		}
+		if len(ambiguous) != expected {
+			t.Error(to, "unexpected ambiguities", ambiguous)
+		}
+	}
+}
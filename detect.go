@@ -0,0 +1,247 @@
+package mbox
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// sniffLen is how much of the stream is examined by Detect
+const sniffLen = 256 * 1024
+
+// gzipMagic starts gzip streams
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Detection is the result of Detect
+type Detection struct {
+	// Variant is the likely variant
+	Variant Variant
+	// Gzip is true if the stream is gzip compressed
+	Gzip bool
+	// Confidence is between 0 (a guess) and 1 (certain)
+	Confidence float64
+	// Evidence describes what the decision was based on
+	Evidence []string
+}
+
+// evidence adds to the evidence
+func (d *Detection) evidence(format string, a ...interface{}) {
+	d.Evidence = append(d.Evidence, fmt.Sprintf(format, a...))
+}
+
+// Detect examines the start of r and reports the likely variant. Up to 256KiB are read from r.
+// InvalidFormat is returned if the stream does not look like any of the variants
+func Detect(r io.Reader) (*Detection, error) {
+	data, err := sniff(r)
+	if err != nil {
+		return nil, err
+	}
+	d := new(Detection)
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(io.MultiReader(bytes.NewReader(data), r))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = sniff(zr); err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		d.Gzip = true
+		d.evidence("gzip magic number")
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte(babylOptions)):
+		d.Variant = Babyl
+		d.Confidence = 1
+		d.evidence("starts with %q", babylOptions)
+	case bytes.HasPrefix(data, []byte(mmdfDelimiter)) || bytes.HasPrefix(data, []byte(mmdfDelimiterCRLF)):
+		d.Variant = MMDF
+		d.Confidence = 1
+		d.evidence("starts with a line of four Ctrl-A characters")
+	case bytes.HasPrefix(data, []byte(header)):
+		detectMbox(data, d)
+	default:
+		return nil, InvalidFormat
+	}
+	return d, nil
+}
+
+// DetectFile is like Detect, for the file at path
+func DetectFile(path string) (*Detection, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Detect(f)
+}
+
+// sniff reads up to sniffLen bytes from r
+func sniff(r io.Reader) ([]byte, error) {
+	data := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+// lines calls f with each line of data (including the eol) and its offset
+func lines(data []byte, f func(line []byte, pos int)) {
+	for pos := 0; pos < len(data); {
+		end := bytes.IndexByte(data[pos:], newLine)
+		if end == -1 {
+			end = len(data)
+		} else {
+			end += pos + 1
+		}
+		f(data[pos:end], pos)
+		pos = end
+	}
+}
+
+// escapedFrom returns the number of ">" before a "From " at the start of the line, or -1
+func escapedFrom(line []byte) int {
+	i := 0
+	for i < len(line) && line[i] == escape {
+		i++
+	}
+	if !bytes.HasPrefix(line[i:], []byte(header)) {
+		return -1
+	}
+	return i
+}
+
+// detectMbox tells apart the variants that start with a "From " line
+func detectMbox(data []byte, d *Detection) {
+	var (
+		messages, single, multi, quoted int
+		prev                            []byte
+		blank                           = true
+	)
+	// first look at the "From " lines, as mboxrd and mboxo would be read
+	lines(data, func(line []byte, _ int) {
+		switch n := escapedFrom(line); {
+		case n == 0 && blank:
+			messages++
+		case n == 1:
+			single++
+			if len(prev) > 0 && prev[0] == escape {
+				// a quoted ">From " which mboxrd would have escaped to ">>From "
+				quoted++
+			}
+		case n > 1:
+			multi++
+		}
+		blank = blankLine(line)
+		prev = line
+	})
+	d.evidence("%d \"From \" lines after a blank line", messages)
+
+	// then follow the Content-Length headers
+	var good, bad, missing, bare, escaped int
+	pos := 0
+	for pos < len(data) {
+		cl, start := readHeaders(data, pos)
+		if start == -1 {
+			// the headers go beyond what was read
+			break
+		}
+		if cl == -1 {
+			missing++
+			break
+		}
+		end := int64(start) + cl
+		if end >= int64(len(data)) {
+			// the message goes beyond what was read
+			break
+		}
+		next := end + 1
+		if data[end] == carriageReturn {
+			next++
+		}
+		if !blankLine(data[end:]) || (next < int64(len(data)) && !bytes.HasPrefix(data[next:], []byte(header))) {
+			bad++
+			break
+		}
+		good++
+		lines(data[start:end], func(line []byte, _ int) {
+			switch escapedFrom(line) {
+			case -1:
+			case 0:
+				bare++
+			default:
+				escaped++
+			}
+		})
+		pos = int(next)
+	}
+
+	switch {
+	case good > 0 && bad == 0 && missing == 0:
+		d.evidence("%d Content-Length headers that end on a boundary", good)
+		if bare > 0 {
+			d.Variant = MboxCL2
+			d.Confidence = 0.9
+			d.evidence("%d unescaped \"From \" lines inside Content-Length bodies", bare)
+		} else if escaped > 0 {
+			d.Variant = MboxCL
+			d.Confidence = 0.8
+			d.evidence("%d escaped \">From \" lines inside Content-Length bodies", escaped)
+		} else {
+			d.Variant = MboxCL
+			d.Confidence = 0.6
+			d.evidence("no \"From \" lines inside the bodies to tell mboxcl from mboxcl2")
+		}
+		return
+	case bad > 0:
+		d.evidence("a Content-Length header does not end on a boundary")
+	case good > 0:
+		d.evidence("%d Content-Length headers, but not on all messages", good)
+	}
+	switch {
+	case multi > 0:
+		d.Variant = MboxRD
+		d.Confidence = 0.8
+		d.evidence("%d \">>From \" lines", multi)
+	case quoted > 0:
+		d.Variant = MboxO
+		d.Confidence = 0.7
+		d.evidence("%d \">From \" lines quoted in replies, but no \">>From \" lines", quoted)
+	case single > 0:
+		d.Variant = MboxRD
+		d.Confidence = 0.5
+		d.evidence("%d \">From \" lines, which could also be mboxo", single)
+	default:
+		d.Variant = MboxRD
+		d.Confidence = 0.5
+		d.evidence("no escaped lines, mboxrd and mboxo are read the same")
+	}
+}
+
+// readHeaders reads the "From " line and the headers of the message at pos, returning the
+// Content-Length (-1 if there's none) and the offset of the body (-1 if it's not in data)
+func readHeaders(data []byte, pos int) (cl int64, start int) {
+	cl = -1
+	first := true
+	for pos < len(data) {
+		end := bytes.IndexByte(data[pos:], newLine)
+		if end == -1 {
+			return cl, -1
+		}
+		line := data[pos : pos+end+1]
+		pos += end + 1
+		if first {
+			first = false
+			continue
+		}
+		if blankLine(line) {
+			return cl, pos
+		}
+		if n, ok := contentLength(line); ok {
+			cl = n
+		}
+	}
+	return cl, -1
+}
@@ -0,0 +1,118 @@
+package mbox
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// write returns the messages encoded in the variant
+func write(t *testing.T, v Variant, messages ...string) []byte {
+	var b bytes.Buffer
+	var w interface {
+		io.Writer
+		Open(from string, t time.Time) error
+		Close() error
+	}
+	switch v {
+	case MMDF:
+		w = NewMMDFWriter(struct{ io.Writer }{&b})
+	case Babyl:
+		w = NewBabylWriter(struct{ io.Writer }{&b})
+	default:
+		w = NewWriter(struct{ io.Writer }{&b}, WithVariant(v))
+	}
+	for _, m := range messages {
+		if err := w.Open("test@example.com", time.Unix(1611714742, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.Bytes()
+}
+
+// writeCRLF returns the messages encoded in the variant, with "\r\n" line endings
+func writeCRLF(t *testing.T, v Variant, messages ...string) []byte {
+	var b bytes.Buffer
+	w := NewMailboxWriter(&b, WithVariant(v), WithLineEnding(LineEndingCRLF))
+	for _, m := range messages {
+		if err := w.BeginMessage(Envelope{From: "test@example.com", Date: time.Unix(1611714742, 0)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDetect(t *testing.T) {
+	const plain = "Subject: plain\n\nhello\n"
+	const from = "Subject: from\n\nhello\n\nFrom the start\n"
+	const escaped = "Subject: escaped\n\n>From the start\n"
+	const quoted = "Subject: quoted\n\n> previous\n>From the start\n"
+	tests := []struct {
+		name     string
+		data     []byte
+		expected Variant
+	}{
+		{"babyl", write(t, Babyl, plain), Babyl},
+		{"mmdf", write(t, MMDF, plain), MMDF},
+		{"mboxrd", write(t, MboxRD, plain, escaped, plain), MboxRD},
+		{"mboxo", write(t, MboxO, plain, quoted), MboxO},
+		{"mboxcl", write(t, MboxCL, plain, from, plain), MboxCL},
+		{"mboxcl2", write(t, MboxCL2, plain, from, plain), MboxCL2},
+		{"plain", write(t, MboxRD, plain, plain), MboxRD},
+		{"mboxcl crlf", writeCRLF(t, MboxCL, plain, escaped, plain), MboxCL},
+		{"mboxcl2 crlf", writeCRLF(t, MboxCL2, plain, from, plain), MboxCL2},
+		{"mboxo crlf", writeCRLF(t, MboxO, plain, quoted), MboxO},
+		{"mmdf crlf", []byte("\x01\x01\x01\x01\r\nSubject: plain\r\n\r\nhello\r\n\x01\x01\x01\x01\r\n"), MMDF},
+	}
+	for _, test := range tests {
+		d, err := Detect(bytes.NewReader(test.data))
+		if err != nil {
+			t.Error(test.name, err)
+			continue
+		}
+		if d.Variant != test.expected {
+			t.Error(test.name, "detected", d.Variant, d.Evidence)
+		}
+		if d.Gzip || d.Confidence <= 0 || d.Confidence > 1 || len(d.Evidence) == 0 {
+			t.Error(test.name, "unexpected detection", d)
+		}
+	}
+
+	if _, err := Detect(bytes.NewReader([]byte("garbage"))); err != InvalidFormat {
+		t.Error("expecting InvalidFormat")
+	}
+}
+
+func TestDetectGzip(t *testing.T) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	_, _ = zw.Write(write(t, MboxCL2, "Subject: from\n\nhello\n\nFrom the start\n"))
+	_ = zw.Close()
+	path := filepath.Join(t.TempDir(), "mbox.gz")
+	if err := os.WriteFile(path, b.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := DetectFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Gzip || d.Variant != MboxCL2 {
+		t.Error("expecting gzip mboxcl2, got", d)
+	}
+}
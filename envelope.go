@@ -0,0 +1,172 @@
+package mbox
+
+import (
+	"bytes"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// remoteFrom is the UUCP suffix that may follow the date
+const remoteFrom = " remote from "
+
+// DateLayouts are the default layouts for parsing the date of a "From " line, tried in order
+var DateLayouts = []string{
+	time.ANSIC,                        // Wed Jan 27 02:32:22 2021
+	time.UnixDate,                     // Wed Jan 27 02:32:22 PST 2021
+	"Mon Jan _2 15:04:05 -0700 2006",  // Wed Jan 27 02:32:22 -0800 2021 (Google Takeout)
+	"Mon Jan _2 15:04:05 2006 -0700",  // Wed Jan 27 02:32:22 2021 -0800
+	"Mon Jan _2 15:04:05 2006 MST",    // Wed Jan 27 02:32:22 2021 PST
+	"Mon Jan _2 15:04 2006",           // Wed Jan 27 02:32 2021
+	"Mon Jan _2 15:04 MST 2006",       // Wed Jan 27 02:32 PST 2021
+	"Mon Jan _2 15:04 -0700 2006",     // Wed Jan 27 02:32 -0800 2021
+	"Mon, _2 Jan 2006 15:04:05 -0700", // Wed, 27 Jan 2021 02:32:22 -0800 (RFC 2822)
+	"Mon, _2 Jan 2006 15:04:05 MST",   // Wed, 27 Jan 2021 02:32:22 PST
+	"Mon, _2 Jan 2006 15:04 -0700",    // Wed, 27 Jan 2021 02:32 -0800
+	"_2 Jan 2006 15:04:05 -0700",      // 27 Jan 2021 02:32:22 -0800
+}
+
+// zoneLayouts are the layout elements for a time zone
+var zoneLayouts = map[string]bool{"MST": true, "-0700": true, "-07:00": true, "-07": true, "Z0700": true, "Z07:00": true}
+
+// zoneOffsets are the offsets east of UTC, in seconds, of common time zone abbreviations.
+// time.Parse gives an abbreviation it doesn't know the offset 0, these are used instead.
+// Ambiguous abbreviations are taken as the North American or European zone
+var zoneOffsets = map[string]int{
+	"UT": 0, "UTC": 0, "GMT": 0, "Z": 0, "WET": 0,
+	"BST": 1 * 3600, "IST": 1 * 3600, "WEST": 1 * 3600, "CET": 1 * 3600, "MET": 1 * 3600,
+	"CEST": 2 * 3600, "MEST": 2 * 3600, "EET": 2 * 3600, "SAST": 2 * 3600,
+	"EEST": 3 * 3600, "MSK": 3 * 3600,
+	"JST": 9 * 3600, "KST": 9 * 3600,
+	"AEST": 10 * 3600, "AEDT": 11 * 3600, "NZST": 12 * 3600, "NZDT": 13 * 3600,
+	"NST": -(3*3600 + 1800), "NDT": -(2*3600 + 1800),
+	"AST": -4 * 3600, "ADT": -3 * 3600,
+	"EST": -5 * 3600, "EDT": -4 * 3600,
+	"CST": -6 * 3600, "CDT": -5 * 3600,
+	"MST": -7 * 3600, "MDT": -6 * 3600,
+	"PST": -8 * 3600, "PDT": -7 * 3600,
+	"AKST": -9 * 3600, "AKDT": -8 * 3600,
+	"HST": -10 * 3600,
+}
+
+// Envelope is the "From " line that precedes each message
+type Envelope struct {
+	// Raw is the entire line, as it appeared in the stream (without the eol)
+	Raw string
+	// From is the sender address
+	From string
+	// Date is the date of the line
+	Date time.Time
+	// Zone is the time zone name or offset as it appeared in the line, empty if there was none.
+	// A name that's not in a small table of common abbreviations is taken as UTC
+	Zone string
+	// Layout is the layout that parsed the date
+	Layout string
+	// Remote is the host of a trailing UUCP "remote from" , empty if there was none
+	Remote string
+	// Offset is the byte offset of the line.
+	// For the writer, it's relative to the first byte written by the writer
+	Offset int64
+	// Babyl holds the attributes and labels of Babyl messages, nil for other formats
+	Babyl *BabylInfo
+}
+
+// ParseEnvelope parses a "From " line, trying each of the layouts in order to parse the date.
+// DateLayouts are used if no layouts are given.
+// If the date is invalid, InvalidHeader is returned together with what could be parsed
+func ParseEnvelope(line string, layouts ...string) (e Envelope, err error) {
+	if len(layouts) == 0 {
+		layouts = DateLayouts
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, string(newLine)), string(carriageReturn))
+	e.Raw = line
+	if !strings.HasPrefix(line, header) {
+		return e, InvalidHeader
+	}
+	s := line[len(header):]
+	i := strings.Index(s, " ")
+	if i == -1 {
+		return e, InvalidHeader
+	}
+	e.From = s[:i]
+	s = strings.TrimLeft(s[i+1:], " ")
+	if strings.HasPrefix(s, "at ") {
+		// mailman archives obscure the address as "user at example.com"
+		if i = strings.Index(s[3:], " "); i != -1 {
+			e.From += " at " + s[3:3+i]
+			s = strings.TrimLeft(s[3+i:], " ")
+		}
+	}
+	if i = strings.LastIndex(s, remoteFrom); i != -1 {
+		e.Remote = s[i+len(remoteFrom):]
+		s = s[:i]
+	}
+	if i = strings.LastIndex(s, " ("); i != -1 && strings.HasSuffix(s, ")") {
+		// a comment such as "(PST)" after the offset
+		s = s[:i]
+	}
+	for _, layout := range layouts {
+		if e.Date, err = time.Parse(layout, s); err == nil {
+			e.Layout = layout
+			e.Zone = zone(layout, s)
+			if offset, ok := zoneOffsets[e.Zone]; ok && e.Zone != "UTC" {
+				d := e.Date
+				e.Date = time.Date(d.Year(), d.Month(), d.Day(), d.Hour(), d.Minute(), d.Second(), d.Nanosecond(),
+					time.FixedZone(e.Zone, offset))
+			}
+			return e, nil
+		}
+	}
+	return e, InvalidHeader
+}
+
+// zone returns the field of value that was parsed by the zone element of layout
+func zone(layout, value string) string {
+	lf := strings.Fields(layout)
+	vf := strings.Fields(value)
+	for i := range lf {
+		if zoneLayouts[lf[i]] && i < len(vf) {
+			return vf[i]
+		}
+	}
+	return ""
+}
+
+// String returns the "From " line. Raw is returned if it is set, otherwise the line is built
+// from From, Date and Remote, the date is formatted in UTC
+func (e *Envelope) String() string {
+	if e.Raw != "" {
+		return e.Raw
+	}
+	var sb strings.Builder
+	sb.WriteString(header)
+	sb.WriteString(e.From)
+	sb.WriteString(" ")
+	sb.WriteString(e.Date.UTC().Format(time.ANSIC))
+	if e.Remote != "" {
+		sb.WriteString(remoteFrom)
+		sb.WriteString(e.Remote)
+	}
+	return sb.String()
+}
+
+// HeaderEnvelope builds an envelope from the header of a message, for messages without a "From " line.
+// The sender is taken from Return-Path or Delivered-To, or is MAILER-DAEMON if neither has an address.
+// The date is taken from Date, or is the zero time
+func HeaderEnvelope(header []byte) Envelope {
+	e := Envelope{From: "MAILER-DAEMON"}
+	msg, err := mail.ReadMessage(bytes.NewReader(append(header[:len(header):len(header)], newLine)))
+	if err != nil {
+		return e
+	}
+	for _, field := range []string{"Return-Path", "Delivered-To"} {
+		if s := strings.Trim(strings.TrimSpace(msg.Header.Get(field)), "<>"); s != "" && !strings.ContainsAny(s, " \t") {
+			e.From = s
+			break
+		}
+	}
+	if d, err := msg.Header.Date(); err == nil {
+		e.Date = d
+	}
+	return e
+}
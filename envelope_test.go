@@ -0,0 +1,170 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestParseEnvelope(t *testing.T) {
+	e, err := ParseEnvelope("From test@example.com Wed Jan 27 02:32:22 2021\n")
+	if err != nil {
+		t.Error(err)
+	}
+	if e.From != "test@example.com" {
+		t.Error("expecting test@example.com")
+	}
+	if e.Date.Unix() != 1611714742 {
+		t.Error("invalid date")
+	}
+	if e.Raw != "From test@example.com Wed Jan 27 02:32:22 2021" {
+		t.Error("unexpected raw line", e.Raw)
+	}
+
+	e, err = ParseEnvelope("From test@example.com Wed Jan 27 02:32:22 2021 remote from uunet")
+	if err != nil {
+		t.Error(err)
+	}
+	if e.Remote != "uunet" {
+		t.Error("expecting remote uunet, got", e.Remote)
+	}
+	if e.Date.Unix() != 1611714742 {
+		t.Error("invalid date")
+	}
+
+	e, err = ParseEnvelope("From test@example.com Wed kjgghghghg")
+	if err != InvalidHeader {
+		t.Error("expecting InvalidHeader")
+	}
+	if e.From != "test@example.com" {
+		t.Error("expecting test@example.com")
+	}
+	if _, err = ParseEnvelope("xrom test@example.com Wed Jan 27 02:32:22 2021"); err != InvalidHeader {
+		t.Error("expecting InvalidHeader")
+	}
+}
+
+func TestEnvelopeString(t *testing.T) {
+	e := Envelope{From: "test@example.com", Date: time.Unix(1611714742, 0), Remote: "uunet"}
+	if e.String() != "From test@example.com Wed Jan 27 02:32:22 2021 remote from uunet" {
+		t.Error("unexpected line", e.String())
+	}
+	e.Raw = "From test@example.com  Wed Jan 27 02:32:22 2021"
+	if e.String() != e.Raw {
+		t.Error("expecting the raw line")
+	}
+}
+
+// the envelope should survive a round trip byte-exactly
+func TestEnvelopeRoundTrip(t *testing.T) {
+	const line = "From test@example.com  Wed Jan 27 02:32:22 2021 remote from uunet"
+	var b bytes.Buffer
+	w := NewWriter(struct{ io.Writer }{&b})
+	if err := w.Open("first@example.com", time.Now()); err != nil {
+		t.Error(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+	in, err := ParseEnvelope(line)
+	if err != nil {
+		t.Error(err)
+	}
+	if err = w.OpenEnvelope(in); err != nil {
+		t.Error(err)
+	}
+	if _, err = w.Write([]byte("hello\n")); err != nil {
+		t.Error(err)
+	}
+	if w.Envelope().Offset != 49 {
+		t.Error("expecting offset 49, got", w.Envelope().Offset)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+
+	s := NewScanner(&b)
+	var out []Envelope
+	for s.Next() {
+		out = append(out, s.Message().Envelope)
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	if len(out) != 2 {
+		t.Fatal("expecting 2 messages")
+	}
+	if out[1].Raw != line {
+		t.Error("unexpected line", out[1].Raw)
+	}
+	if out[1].Offset != 49 {
+		t.Error("expecting offset 49, got", out[1].Offset)
+	}
+}
+
+func TestParseEnvelopeLayouts(t *testing.T) {
+	tests := []struct {
+		line   string
+		from   string
+		unix   int64
+		zone   string
+		layout string
+	}{
+		{"From - Wed Jan 27 02:32:22 2021", "-", 1611714742, "", time.ANSIC},
+		{"From test@example.com Wed Jan  7 02:32:22 2021", "test@example.com", 1609986742, "", time.ANSIC},
+		{"From 1234@xxx Wed Jan 27 02:32:22 -0800 2021", "1234@xxx", 1611743542, "-0800", "Mon Jan _2 15:04:05 -0700 2006"},
+		{"From test@example.com Wed Jan 27 02:32:22 2021 +0100", "test@example.com", 1611711142, "+0100", "Mon Jan _2 15:04:05 2006 -0700"},
+		{"From test@example.com Wed Jan 27 02:32:22 UTC 2021", "test@example.com", 1611714742, "UTC", time.UnixDate},
+		{"From test@example.com Wed Jan 27 02:32 2021", "test@example.com", 1611714720, "", "Mon Jan _2 15:04 2006"},
+		{"From a Wed Jan 27 02:32:22 PST 2021", "a", 1611743542, "PST", time.UnixDate},
+		{"From a Wed Jan 27 02:32:22 2021 CET", "a", 1611711142, "CET", "Mon Jan _2 15:04:05 2006 MST"},
+		{"From a Wed Jan 27 02:32 EST 2021", "a", 1611732720, "EST", "Mon Jan _2 15:04 MST 2006"},
+		{"From a Wed Jan 27 02:32:22 XYZ 2021", "a", 1611714742, "XYZ", time.UnixDate},
+		{"From test@example.com Wed, 27 Jan 2021 02:32:22 -0800 (PST)", "test@example.com", 1611743542, "-0800", "Mon, _2 Jan 2006 15:04:05 -0700"},
+		{"From test at example.com  Wed Jan 27 02:32:22 2021", "test at example.com", 1611714742, "", time.ANSIC},
+	}
+	for _, test := range tests {
+		e, err := ParseEnvelope(test.line)
+		if err != nil {
+			t.Error(test.line, err)
+			continue
+		}
+		if e.From != test.from {
+			t.Error(test.line, "unexpected from", e.From)
+		}
+		if e.Date.Unix() != test.unix {
+			t.Error(test.line, "unexpected date", e.Date.Unix())
+		}
+		if e.Zone != test.zone {
+			t.Error(test.line, "unexpected zone", e.Zone)
+		}
+		if e.Layout != test.layout {
+			t.Error(test.line, "unexpected layout", e.Layout)
+		}
+	}
+
+	// only the given layouts are tried
+	if _, err := ParseEnvelope("From test@example.com Wed Jan 27 02:32 2021", time.ANSIC); err != InvalidHeader {
+		t.Error("expecting InvalidHeader")
+	}
+}
+
+func TestScannerDateLayouts(t *testing.T) {
+	const in = "From test@example.com 2021-01-27 02:32:22\nhello\n\n"
+	s := NewScanner(bytes.NewReader([]byte(in)))
+	if s.Next() || !errors.Is(s.Err(), InvalidHeader) {
+		t.Error("expecting InvalidHeader")
+	}
+	s = NewScanner(bytes.NewReader([]byte(in)), WithDateLayouts(time.DateTime))
+	if !s.Next() {
+		t.Fatal(s.Err())
+	}
+	if s.Message().Envelope.Date.Unix() != 1611714742 {
+		t.Error("invalid date")
+	}
+	if s.Message().Envelope.Layout != time.DateTime {
+		t.Error("unexpected layout")
+	}
+}
@@ -0,0 +1,59 @@
+package mbox
+
+import "fmt"
+
+// ParseError is returned by the decoder when the stream is invalid. It wraps InvalidFormat or InvalidHeader,
+// so they can be tested with errors.Is. In strict mode, it's also reported for UnescapedFrom
+type ParseError struct {
+	// Err is InvalidFormat, InvalidHeader or UnescapedFrom
+	Err error
+	// Offset is the byte offset in the stream where the error was found
+	Offset int64
+	// Line is the line number of Offset, starting at 1
+	Line int64
+	// Message is the index of the message, starting at 0
+	Message int
+	// State is the name of the state of the decoder, for debugging. It's empty for MMDF and Babyl
+	State string
+}
+
+// Error implements error
+func (e *ParseError) Error() string {
+	if e.State == "" {
+		return fmt.Sprintf("%v at line %d, offset %d (message %d)", e.Err, e.Line, e.Offset, e.Message)
+	}
+	return fmt.Sprintf("%v at line %d, offset %d (message %d, state %s)", e.Err, e.Line, e.Offset, e.Message, e.State)
+}
+
+// Unwrap returns Err
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// readStateNames are the names of the states, for String
+var readStateNames = []string{
+	readStateHeaderMagic:    "HeaderMagic",
+	readStateHeaderValues:   "HeaderValues",
+	readStateStartLine:      "StartLine",
+	readStatePutEol:         "PutEol",
+	readStateCopy:           "Copy",
+	readStateMatchFrom:      "MatchFrom",
+	readStateOutputFrom:     "OutputFrom",
+	readStateHeaderMagicEOF: "HeaderMagicEOF",
+	readStateEnd:            "End",
+	readStateNextRecord:     "NextRecord",
+	readStateCLHeader:       "CLHeader",
+	readStateCLHeaderCopy:   "CLHeaderCopy",
+	readStateCLBody:         "CLBody",
+	readStateCLEnd:          "CLEnd",
+	readStateSkip:           "Skip",
+	readStateCR:             "CR",
+}
+
+// String returns the name of the state
+func (s readState) String() string {
+	if s < 0 || int(s) >= len(readStateNames) {
+		return fmt.Sprintf("readState(%d)", int(s))
+	}
+	return readStateNames[s]
+}
@@ -0,0 +1,44 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	const in = "From a@example.com Wed Jan 27 02:32:22 2021\nSubject: one\n\nbody\n\n" +
+		"From b@example.com Wed Jan 27 02:32:23 2021\nSubject: two\n\nno blank line at the end\n"
+	s := NewScanner(strings.NewReader(in))
+	for s.Next() {
+		_, _ = io.Copy(io.Discard, s.Message().Body)
+	}
+	var pe *ParseError
+	if !errors.As(s.Err(), &pe) {
+		t.Fatal("expecting a *ParseError, got", s.Err())
+	}
+	if !errors.Is(pe, InvalidFormat) {
+		t.Error("expecting InvalidFormat")
+	}
+	if pe.Offset != int64(len(in)) || pe.Line != 10 || pe.Message != 1 || pe.State != "StartLine" {
+		t.Error("unexpected error", pe)
+	}
+	if pe.Error() != "invalid file format at line 10, offset 147 (message 1, state StartLine)" {
+		t.Error("unexpected message", pe.Error())
+	}
+}
+
+func TestParseErrorHeader(t *testing.T) {
+	const in = "From a@example.com Wed Jan 27 02:32:22 2021\n\nbody\n\nFrom b@example.com bad date\n\n"
+	s := NewScanner(strings.NewReader(in))
+	for s.Next() {
+	}
+	var pe *ParseError
+	if !errors.As(s.Err(), &pe) || !errors.Is(pe, InvalidHeader) {
+		t.Fatal("expecting a *ParseError with InvalidHeader, got", s.Err())
+	}
+	if pe.Offset != 51 || pe.Line != 5 || pe.Message != 1 {
+		t.Error("unexpected error", pe)
+	}
+}
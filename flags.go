@@ -0,0 +1,167 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+const (
+	statusField  = "Status:"
+	xStatusField = "X-Status:"
+)
+
+// Flags are the state of a message, as kept by mail clients in the Status and X-Status fields
+type Flags uint8
+
+const (
+	// Seen is "R" in Status
+	Seen Flags = 1 << iota
+	// Old is "O" in Status, the message is no longer new
+	Old
+	// Answered is "A" in X-Status
+	Answered
+	// Flagged is "F" in X-Status
+	Flagged
+	// Draft is "T" in X-Status
+	Draft
+	// Deleted is "D" in X-Status
+	Deleted
+)
+
+// statusLetters are the letters of the Status field, in the order they are written
+var statusLetters = []struct {
+	f Flags
+	c byte
+}{{Seen, 'R'}, {Old, 'O'}}
+
+// xStatusLetters are the letters of the X-Status field, in the order they are written
+var xStatusLetters = []struct {
+	f Flags
+	c byte
+}{{Answered, 'A'}, {Deleted, 'D'}, {Flagged, 'F'}, {Draft, 'T'}}
+
+// Status returns the value of the Status field
+func (f Flags) Status() string {
+	var sb strings.Builder
+	for _, l := range statusLetters {
+		if f&l.f != 0 {
+			sb.WriteByte(l.c)
+		}
+	}
+	return sb.String()
+}
+
+// XStatus returns the value of the X-Status field
+func (f Flags) XStatus() string {
+	var sb strings.Builder
+	for _, l := range xStatusLetters {
+		if f&l.f != 0 {
+			sb.WriteByte(l.c)
+		}
+	}
+	return sb.String()
+}
+
+// ParseFlags returns the flags of the Status and X-Status values, unknown letters are ignored
+func ParseFlags(status, xStatus string) (f Flags) {
+	for _, l := range statusLetters {
+		if strings.IndexByte(status, l.c) != -1 {
+			f |= l.f
+		}
+	}
+	for _, l := range xStatusLetters {
+		if strings.IndexByte(xStatus, l.c) != -1 {
+			f |= l.f
+		}
+	}
+	return f
+}
+
+// ReadHeader reads the header of a message from r, up to and including the blank line that ends it.
+// If the message has no body, the header is returned without a blank line
+func ReadHeader(r *bufio.Reader) ([]byte, error) {
+	var header []byte
+	for {
+		line, err := r.ReadSlice(newLine)
+		header = append(header, line...)
+		if err == io.EOF {
+			return header, nil
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return header, err
+		}
+		if err == nil && blankLine(line) && len(line) <= 2 && (len(header) == len(line) || header[len(header)-len(line)-1] == newLine) {
+			return header, nil
+		}
+	}
+}
+
+// HeaderFlags returns the flags from the Status and X-Status fields of header
+func HeaderFlags(header []byte) Flags {
+	var status, xStatus string
+	fields(header, func(field []byte) bool {
+		if isField(field, statusField) {
+			status = string(field[len(statusField):])
+		} else if isField(field, xStatusField) {
+			xStatus = string(field[len(xStatusField):])
+		}
+		return true
+	})
+	return ParseFlags(status, xStatus)
+}
+
+// SetHeaderFlags returns a copy of header, with the Status and X-Status fields replaced by those of f.
+// The fields are added at the end of the header, they are left out if they would be empty
+func SetHeaderFlags(header []byte, f Flags) []byte {
+	var out bytes.Buffer
+	// the fields are added with the line ending of the header
+	le := "\n"
+	if i := bytes.IndexByte(header, newLine); i > 0 && header[i-1] == carriageReturn {
+		le = "\r\n"
+	}
+	fields(header, func(field []byte) bool {
+		if !isField(field, statusField) && !isField(field, xStatusField) {
+			out.Write(field)
+			if field[len(field)-1] != newLine {
+				out.WriteString(le)
+			}
+		}
+		return true
+	})
+	if s := f.Status(); s != "" {
+		out.WriteString(statusField + " " + s + le)
+	}
+	if s := f.XStatus(); s != "" {
+		out.WriteString(xStatusField + " " + s + le)
+	}
+	if h, _ := headerEnd(header); h != -1 || blankLine(header) {
+		out.WriteString(le)
+	}
+	return out.Bytes()
+}
+
+// fields calls f with each field of header, including continuation lines, until f returns false.
+// The blank line that ends the header is not passed to f
+func fields(header []byte, f func(field []byte) bool) {
+	start, pos := -1, 0
+	for pos < len(header) && !blankLine(header[pos:]) {
+		if header[pos] != ' ' && header[pos] != '\t' {
+			if start != -1 && !f(header[start:pos]) {
+				return
+			}
+			start = pos
+		} else if start == -1 {
+			// a continuation without a field
+			start = pos
+		}
+		if end := bytes.IndexByte(header[pos:], newLine); end != -1 {
+			pos += end + 1
+		} else {
+			pos = len(header)
+		}
+	}
+	if start != -1 {
+		f(header[start:pos])
+	}
+}
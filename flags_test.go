@@ -0,0 +1,53 @@
+package mbox
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+const flagsTest1 = "Subject: test\nStatus: RO\nX-Status: AF\n  continued\nTo: null@example.com\n\nbody\n"
+
+func TestReadHeaderBlock(t *testing.T) {
+	header, err := ReadHeader(bufio.NewReader(strings.NewReader(flagsTest1)))
+	if err != nil {
+		t.Error(err)
+	}
+	if string(header) != flagsTest1[:len(flagsTest1)-5] {
+		t.Error("unexpected header", string(header))
+	}
+	// no body
+	header, err = ReadHeader(bufio.NewReader(strings.NewReader("Subject: test\n")))
+	if err != nil || string(header) != "Subject: test\n" {
+		t.Error("unexpected header", string(header), err)
+	}
+	header, err = ReadHeader(bufio.NewReader(strings.NewReader("Subject: test\r\n\r\nbody\r\n")))
+	if err != nil || string(header) != "Subject: test\r\n\r\n" {
+		t.Errorf("unexpected header %q %v", header, err)
+	}
+}
+
+func TestHeaderFlags(t *testing.T) {
+	f := HeaderFlags([]byte(flagsTest1))
+	if f != Seen|Old|Answered|Flagged {
+		t.Error("unexpected flags", f)
+	}
+	if f.Status() != "RO" || f.XStatus() != "AF" {
+		t.Error("unexpected values", f.Status(), f.XStatus())
+	}
+}
+
+func TestSetHeaderFlags(t *testing.T) {
+	header := SetHeaderFlags([]byte(flagsTest1[:len(flagsTest1)-5]), Seen|Deleted)
+	expected := "Subject: test\nTo: null@example.com\nStatus: R\nX-Status: D\n\n"
+	if string(header) != expected {
+		t.Error("unexpected header", string(header))
+	}
+	if header = SetHeaderFlags([]byte("Subject: test\nStatus: O\n"), 0); string(header) != "Subject: test\n" {
+		t.Error("unexpected header", string(header))
+	}
+	header = SetHeaderFlags([]byte("Subject: test\r\nStatus: O\r\n\r\n"), Seen)
+	if string(header) != "Subject: test\r\nStatus: R\r\n\r\n" {
+		t.Errorf("unexpected header %q", header)
+	}
+}
@@ -0,0 +1,220 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"iter"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// HeaderRecord is a message read by a HeaderScanner
+type HeaderRecord struct {
+	// Envelope is the "From " line of the message
+	Envelope Envelope
+	// Header is the parsed header of the message
+	Header textproto.MIMEHeader
+	// BodyOffset is the offset of the body, after the blank line that ends the header
+	BodyOffset int64
+	// BodyLength is the length of the body as it is in the mailbox, still escaped, without the blank line
+	// that separates it from the next message
+	BodyLength int64
+}
+
+// HeaderScanner reads the envelope and header of each message, and skips the body without unescaping it.
+// It's faster than a Scanner when the bodies are not needed.
+// Only the variants with a "From " line can be read
+type HeaderScanner struct {
+	r  *bufio.Reader
+	tr *textproto.Reader
+	cr *countingReader
+	// line is the "From " line of the next message, and lineOffset its offset
+	line       []byte
+	lineOffset int64
+	started    bool
+	rec        *HeaderRecord
+	err        error
+
+	config
+}
+
+// NewHeaderScanner returns a HeaderScanner, ready to read messages from r
+func NewHeaderScanner(r io.Reader, opts ...Option) *HeaderScanner {
+	s := &HeaderScanner{cr: &countingReader{r: r}, config: newConfig(opts)}
+	s.r = bufio.NewReaderSize(s.cr, defaultBufferSize)
+	s.tr = textproto.NewReader(s.r)
+	return s
+}
+
+// pos returns the offset of the next byte from r
+func (s *HeaderScanner) pos() int64 {
+	return s.cr.n - int64(s.r.Buffered())
+}
+
+// readLine reads the rest of a line that was longer than the buffer into s.line
+func (s *HeaderScanner) readLine() error {
+	for {
+		line, err := s.r.ReadSlice(newLine)
+		s.line = append(s.line, line...)
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+	}
+}
+
+// Next advances to the next message, which will be available with Record.
+// It returns false when there are no more messages or an error occurred
+func (s *HeaderScanner) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	s.rec = nil
+	if !s.started {
+		s.started = true
+		if !hasEnvelope(s.variant) {
+			s.err = InvalidFormat
+			return false
+		}
+		if err := s.readLine(); err != nil {
+			s.err = err
+			return false
+		}
+		if len(s.line) == 0 {
+			return false
+		}
+		if !bytes.HasPrefix(s.line, []byte(header)) {
+			s.err = InvalidFormat
+			return false
+		}
+	}
+	if s.line == nil {
+		return false
+	}
+	e, err := ParseEnvelope(string(s.line), s.layouts...)
+	if err != nil {
+		s.err = err
+		return false
+	}
+	e.Offset = s.lineOffset
+	rec := &HeaderRecord{Envelope: e}
+	rec.Header, err = s.tr.ReadMIMEHeader()
+	rec.BodyOffset = s.pos()
+	if err == io.EOF {
+		// the header is not followed by a blank line
+		s.line = nil
+		s.rec = rec
+		return true
+	} else if err != nil {
+		s.err = err
+		return false
+	}
+	cl := int64(-1)
+	if s.variant == MboxCL || s.variant == MboxCL2 {
+		if n, err := strconv.ParseInt(strings.TrimSpace(rec.Header.Get("Content-Length")), 10, 64); err == nil && n >= 0 {
+			cl = n
+		}
+	}
+	if s.err = s.skip(rec, cl); s.err != nil {
+		return false
+	}
+	s.rec = rec
+	return true
+}
+
+// skip reads the body, up to the next "From " line that follows a blank line, and sets the body length.
+// If cl is not -1, it's the Content-Length, and that many bytes are skipped first
+func (s *HeaderScanner) skip(rec *HeaderRecord, cl int64) error {
+	s.line = s.line[:0]
+	lineStart := true
+	// blank is the offset of the previous line if it was blank, the header ends with one
+	blank := rec.BodyOffset - 1
+	if cl != -1 {
+		if n, err := s.r.Discard(int(cl)); err == io.EOF {
+			// the Content-Length goes beyond the stream
+			rec.BodyLength = int64(n)
+			s.line = nil
+			return InvalidFormat
+		} else if err != nil {
+			return err
+		}
+		b, err := s.r.Peek(2)
+		if err == io.EOF && len(b) == 0 {
+			rec.BodyLength = cl
+			s.line = nil
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		if blankLine(b) {
+			blank = s.pos()
+			_, _ = s.r.Discard(1 + bytes.IndexByte(b, newLine))
+		} else {
+			// a wrong Content-Length, continue with the "From " lines
+			blank = -1
+			lineStart = false
+		}
+	}
+	for {
+		pos := s.pos()
+		line, err := s.r.ReadSlice(newLine)
+		if len(line) > 0 {
+			if lineStart && blank != -1 && bytes.HasPrefix(line, []byte(header)) {
+				rec.BodyLength = max(blank-rec.BodyOffset, 0)
+				s.line = append(s.line, line...)
+				s.lineOffset = pos
+				if err == bufio.ErrBufferFull {
+					return s.readLine()
+				}
+				return nil
+			}
+			if lineStart && blankLine(line) {
+				blank = pos
+			} else {
+				blank = -1
+			}
+			lineStart = line[len(line)-1] == newLine
+		}
+		if err == io.EOF {
+			// the last message, without the blank line after it
+			end := s.pos()
+			if blank != -1 {
+				end = blank
+			}
+			rec.BodyLength = max(end-rec.BodyOffset, 0)
+			s.line = nil
+			return nil
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+}
+
+// Record returns the current message
+func (s *HeaderScanner) Record() *HeaderRecord {
+	return s.rec
+}
+
+// Err returns the first error that stopped the HeaderScanner, nil if it stopped at the end of the stream
+func (s *HeaderScanner) Err() error {
+	return s.err
+}
+
+// All returns an iterator over the remaining messages.
+// If an error stops the iteration, it is yielded with a nil record as the last value
+func (s *HeaderScanner) All() iter.Seq2[*HeaderRecord, error] {
+	return func(yield func(*HeaderRecord, error) bool) {
+		for s.Next() {
+			if !yield(s.rec, nil) {
+				return
+			}
+		}
+		if s.err != nil {
+			yield(nil, s.err)
+		}
+	}
+}
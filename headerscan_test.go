@@ -0,0 +1,119 @@
+package mbox
+
+import (
+	"strings"
+	"testing"
+)
+
+const headerScanTest1 = `From a@example.com Wed Jan 27 02:32:22 2021
+Subject: one
+Message-ID: <one@example.com>
+X-Long: folded
+  value
+
+>From escaped
+
+From b@example.com Wed Jan 27 02:32:23 2021
+Subject: two
+
+From c@example.com Wed Jan 27 02:32:24 2021
+
+body
+
+`
+
+func TestHeaderScanner(t *testing.T) {
+	s := NewHeaderScanner(strings.NewReader(headerScanTest1))
+	var recs []*HeaderRecord
+	for rec, err := range s.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 3 {
+		t.Fatal("expecting 3 messages, got", len(recs))
+	}
+	if recs[0].Header.Get("Message-Id") != "<one@example.com>" || recs[0].Header.Get("X-Long") != "folded value" {
+		t.Error("unexpected header", recs[0].Header)
+	}
+	body := headerScanTest1[recs[0].BodyOffset : recs[0].BodyOffset+recs[0].BodyLength]
+	if body != ">From escaped\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if recs[1].Envelope.From != "b@example.com" || recs[1].Envelope.Offset != int64(strings.Index(headerScanTest1, "From b@")) {
+		t.Error("unexpected envelope", recs[1].Envelope)
+	}
+	if recs[1].Header.Get("Subject") != "two" || recs[1].BodyLength != 0 {
+		t.Error("unexpected record", recs[1])
+	}
+	body = headerScanTest1[recs[2].BodyOffset : recs[2].BodyOffset+recs[2].BodyLength]
+	if len(recs[2].Header) != 0 || body != "body\n" {
+		t.Errorf("unexpected record %v %q", recs[2].Header, body)
+	}
+}
+
+func TestHeaderScannerCRLF(t *testing.T) {
+	s := NewHeaderScanner(strings.NewReader(crlfTest))
+	var recs []*HeaderRecord
+	for rec, err := range s.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 2 {
+		t.Fatal("expecting 2 messages, got", len(recs))
+	}
+	body := crlfTest[recs[0].BodyOffset : recs[0].BodyOffset+recs[0].BodyLength]
+	if recs[0].Header.Get("Subject") != "one" || body != ">From the start\r\n\r\nbody\r\n" {
+		t.Errorf("unexpected record %v %q", recs[0].Header, body)
+	}
+	body = crlfTest[recs[1].BodyOffset : recs[1].BodyOffset+recs[1].BodyLength]
+	if recs[1].Envelope.From != "b@example.com" || recs[1].Header.Get("Subject") != "two" || body != "two\r\n" {
+		t.Errorf("unexpected record %v %q", recs[1].Header, body)
+	}
+
+	const cl = "From a@example.com Wed Jan 27 02:32:22 2021\r\nContent-Length: 29\r\n\r\nbody\r\n\r\nFrom not a boundary\r\n\r\n" +
+		"From b@example.com Wed Jan 27 02:32:23 2021\r\nContent-Length: 5\r\n\r\ntwo\r\n\r\n"
+	s = NewHeaderScanner(strings.NewReader(cl), WithVariant(MboxCL2))
+	var lengths []int64
+	for s.Next() {
+		lengths = append(lengths, s.Record().BodyLength)
+	}
+	if s.Err() != nil || len(lengths) != 2 || lengths[0] != 29 || lengths[1] != 5 {
+		t.Error("unexpected lengths", lengths, s.Err())
+	}
+}
+
+func TestHeaderScannerContentLength(t *testing.T) {
+	const in = "From a@example.com Wed Jan 27 02:32:22 2021\nContent-Length: 26\n\nbody\n\nFrom not a boundary\n\n" +
+		"From b@example.com Wed Jan 27 02:32:23 2021\nContent-Length: 2\n\nwrong length\n\n" +
+		"From c@example.com Wed Jan 27 02:32:24 2021\n\n"
+	s := NewHeaderScanner(strings.NewReader(in), WithVariant(MboxCL2))
+	var lengths []int64
+	for s.Next() {
+		lengths = append(lengths, s.Record().BodyLength)
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	if len(lengths) != 3 || lengths[0] != 26 || lengths[1] != 13 || lengths[2] != 0 {
+		t.Error("unexpected lengths", lengths)
+	}
+}
+
+func TestHeaderScannerBad(t *testing.T) {
+	for _, in := range []string{"garbage\n", "From a@example.com Wed Jan 27 02:32:22 2021\n\nbody\n\nFrom bad date\n"} {
+		s := NewHeaderScanner(strings.NewReader(in))
+		for s.Next() {
+		}
+		if s.Err() == nil {
+			t.Errorf("%q expecting an error", in)
+		}
+	}
+	s := NewHeaderScanner(strings.NewReader(""))
+	if s.Next() || s.Err() != nil {
+		t.Error("expecting no messages", s.Err())
+	}
+}
@@ -0,0 +1,317 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// indexMagic starts an index file, the last byte is the version
+const indexMagic = "MBOXIDX\x01"
+
+// maxIndexString is the maximum length of a string read from an index file
+const maxIndexString = 64 * 1024
+
+// IndexSuffix is added to the path of a mailbox for the path of its index file
+const IndexSuffix = ".idx"
+
+const (
+	// indexTrailer is the tag of the trailer, which ends the index
+	indexTrailer = 0
+	// indexEntry is the tag of an entry
+	indexEntry = 1
+)
+
+// IndexEntry is the position of a message in a mailbox
+type IndexEntry struct {
+	// Offset is the offset of the "From " line
+	Offset int64
+	// BodyOffset is the offset of the body, after the blank line that ends the header
+	BodyOffset int64
+	// End is the offset after the message, including the blank line that separates it from the next
+	End int64
+	// Envelope is the "From " line of the message
+	Envelope Envelope
+	// MessageID is the Message-ID field, without the angle brackets
+	MessageID string
+}
+
+// Index holds the positions of the messages in a mailbox, so that any message can be read without
+// scanning the mailbox. Only the variants with a "From " line can be indexed
+type Index struct {
+	// Size is the size of the mailbox that was indexed
+	Size int64
+	// Entries are the messages, in order
+	Entries []IndexEntry
+
+	config
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements io.Reader
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// BuildIndex reads the mailbox from r once, and returns its index
+func BuildIndex(r io.Reader, opts ...Option) (*Index, error) {
+	x := &Index{config: newConfig(opts)}
+	if !hasEnvelope(x.variant) {
+		return nil, InvalidFormat
+	}
+	return x, x.scan(r, 0)
+}
+
+// scan adds the messages read from r to the index, base is the offset of r in the mailbox
+func (x *Index) scan(r io.Reader, base int64) error {
+	cr := &countingReader{r: r}
+	s := NewScanner(cr, WithVariant(x.variant), WithDateLayouts(x.layouts...))
+	first := len(x.Entries)
+	for s.Next() {
+		m := s.Message()
+		br := bufio.NewReader(m.Body)
+		header, err := ReadHeader(br)
+		if err != nil {
+			return err
+		}
+		e := m.Envelope
+		e.Offset += base
+		// the "From " line may end with "\r\n", which is not in e.Raw
+		from := int64(len(e.Raw)) + 1
+		if d, ok := s.d.(interface{ envelopeLength() int64 }); ok {
+			from = d.envelopeLength()
+		}
+		if n := len(x.Entries); n > first {
+			x.Entries[n-1].End = e.Offset
+		}
+		x.Entries = append(x.Entries, IndexEntry{
+			Offset:     e.Offset,
+			BodyOffset: e.Offset + from + x.rawLength(header),
+			Envelope:   e,
+			MessageID:  messageID(header),
+		})
+	}
+	if s.Err() != nil {
+		return s.Err()
+	}
+	x.Size = base + cr.n
+	if n := len(x.Entries); n > first {
+		x.Entries[n-1].End = x.Size
+	}
+	return nil
+}
+
+// rawLength returns the length of the header as it is in the mailbox, before it was unescaped
+func (x *Index) rawLength(header []byte) int64 {
+	n := int64(len(header))
+	if x.variant == MboxRD {
+		lines(header, func(line []byte, _ int) {
+			if escapedFrom(line) != -1 {
+				// assume that the writer escaped it
+				n++
+			}
+		})
+	}
+	return n
+}
+
+// messageID returns the Message-ID field of header, without the angle brackets
+func messageID(header []byte) string {
+	msg, err := mail.ReadMessage(bytes.NewReader(append(header[:len(header):len(header)], newLine)))
+	if err != nil {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(msg.Header.Get("Message-Id")), "<>")
+}
+
+// Update indexes the messages that were appended to the mailbox since it was indexed, size is the
+// current size of the mailbox. InvalidFormat is returned if the mailbox is smaller than when it was indexed
+func (x *Index) Update(r io.ReaderAt, size int64) error {
+	if size < x.Size {
+		return InvalidFormat
+	}
+	if size == x.Size {
+		return nil
+	}
+	return x.scan(io.NewSectionReader(r, x.Size, size-x.Size), x.Size)
+}
+
+// Len returns the number of messages
+func (x *Index) Len() int {
+	return len(x.Entries)
+}
+
+// Section returns a reader for message n as it is in the mailbox r, from the "From " line to End
+func (x *Index) Section(r io.ReaderAt, n int) *io.SectionReader {
+	e := &x.Entries[n]
+	return io.NewSectionReader(r, e.Offset, e.End-e.Offset)
+}
+
+// Message returns message n of the mailbox r, its body is unescaped
+func (x *Index) Message(r io.ReaderAt, n int) *Message {
+	d := NewReader(x.Section(r, n), WithVariant(x.variant), WithDateLayouts(x.layouts...))
+	return &Message{Envelope: x.Entries[n].Envelope, Body: d}
+}
+
+// WriteTo writes the index in a compact binary form, which can be read by ReadIndex
+func (x *Index) WriteTo(w io.Writer) (int64, error) {
+	c := counter{w: w}
+	bw := bufio.NewWriter(&c)
+	var buf [binary.MaxVarintLen64]byte
+	uvarint := func(v uint64) {
+		_, _ = bw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+	str := func(s string) {
+		uvarint(uint64(len(s)))
+		_, _ = bw.WriteString(s)
+	}
+	_, _ = bw.WriteString(indexMagic)
+	_ = bw.WriteByte(byte(x.variant))
+	var end int64
+	for i := range x.Entries {
+		e := &x.Entries[i]
+		_ = bw.WriteByte(indexEntry)
+		// the offsets are stored as differences, which are small
+		uvarint(uint64(e.Offset - end))
+		uvarint(uint64(e.BodyOffset - e.Offset))
+		uvarint(uint64(e.End - e.BodyOffset))
+		str(e.Envelope.Raw)
+		str(e.MessageID)
+		end = e.End
+	}
+	_ = bw.WriteByte(indexTrailer)
+	uvarint(uint64(x.Size))
+	uvarint(uint64(len(x.Entries)))
+	err := bw.Flush()
+	return c.n, err
+}
+
+// ReadIndex reads an index that was written by WriteTo.
+// The envelopes are parsed using the date layouts given by opts.
+// InvalidFormat is returned if the index is not complete
+func ReadIndex(r io.Reader, opts ...Option) (*Index, error) {
+	x := &Index{config: newConfig(opts)}
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(indexMagic)+1)
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic[:len(indexMagic)]) != indexMagic {
+		return nil, InvalidFormat
+	}
+	x.variant = Variant(magic[len(indexMagic)])
+	var err error
+	uvarint := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v uint64
+		v, err = binary.ReadUvarint(br)
+		return int64(v)
+	}
+	str := func() string {
+		n := uvarint()
+		if err != nil || n > maxIndexString {
+			err = InvalidFormat
+			return ""
+		}
+		b := make([]byte, n)
+		if _, err = io.ReadFull(br, b); err != nil {
+			return ""
+		}
+		return string(b)
+	}
+	var end int64
+	for {
+		tag, rerr := br.ReadByte()
+		if rerr != nil {
+			return nil, InvalidFormat
+		}
+		if tag == indexTrailer {
+			x.Size = uvarint()
+			if n := uvarint(); err != nil || n != int64(len(x.Entries)) || x.Size != end {
+				return nil, InvalidFormat
+			}
+			return x, nil
+		} else if tag != indexEntry {
+			return nil, InvalidFormat
+		}
+		e := IndexEntry{Offset: end + uvarint()}
+		e.BodyOffset = e.Offset + uvarint()
+		e.End = e.BodyOffset + uvarint()
+		raw := str()
+		e.MessageID = str()
+		if err != nil {
+			return nil, InvalidFormat
+		}
+		e.Envelope, _ = ParseEnvelope(raw, x.layouts...)
+		e.Envelope.Offset = e.Offset
+		x.Entries = append(x.Entries, e)
+		end = e.End
+	}
+}
+
+// IndexFile returns the index of the mailbox at path, kept in a file at path+IndexSuffix.
+// The index file is created if it doesn't exist, and updated if messages were appended to the mailbox
+func IndexFile(path string, opts ...Option) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	var x *Index
+	if idx, err := os.Open(path + IndexSuffix); err == nil {
+		x, err = ReadIndex(idx, opts...)
+		_ = idx.Close()
+		if err == nil && x.variant != newConfig(opts).variant {
+			err = InvalidFormat
+		}
+		if err == nil && x.Size == fi.Size() {
+			return x, nil
+		}
+		if err != nil || x.Update(f, fi.Size()) != nil {
+			// rebuild the index
+			x = nil
+		}
+	}
+	if x == nil {
+		if x, err = BuildIndex(f, opts...); err != nil {
+			return nil, err
+		}
+	}
+	return x, writeIndexFile(path+IndexSuffix, x)
+}
+
+// writeIndexFile replaces the index file at path
+func writeIndexFile(path string, x *Index) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err = x.WriteTo(tmp); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
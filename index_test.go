@@ -0,0 +1,131 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const indexTest1 = `From test@example.com Wed Jan 27 02:32:22 2021
+Subject: one
+Message-ID: <one@example.com>
+
+>From the start
+
+From test2@example.com Wed Jan 27 02:32:23 2021
+Subject: two
+>From in the header
+
+body
+
+`
+
+func TestBuildIndex(t *testing.T) {
+	x, err := BuildIndex(strings.NewReader(indexTest1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 2 {
+		t.Fatal("expecting 2 messages, got", x.Len())
+	}
+	if x.Entries[0].Offset != 0 || x.Entries[0].BodyOffset != 91 || x.Entries[0].End != 108 {
+		t.Error("unexpected entry", x.Entries[0])
+	}
+	if x.Entries[1].Offset != 108 || x.Entries[1].BodyOffset != 190 || x.Entries[1].End != 196 {
+		t.Error("unexpected entry", x.Entries[1])
+	}
+	if x.Entries[0].MessageID != "one@example.com" || x.Entries[1].Envelope.From != "test2@example.com" {
+		t.Error("unexpected entries", x.Entries)
+	}
+	if x.Size != int64(len(indexTest1)) {
+		t.Error("unexpected size", x.Size)
+	}
+	r := strings.NewReader(indexTest1)
+	body, err := io.ReadAll(x.Message(r, 1).Body)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(body) != "Subject: two\nFrom in the header\n\nbody\n" {
+		t.Error("unexpected body", string(body))
+	}
+	raw, _ := io.ReadAll(io.NewSectionReader(r, x.Entries[0].BodyOffset, x.Entries[0].End-x.Entries[0].BodyOffset))
+	if string(raw) != ">From the start\n\n" {
+		t.Error("unexpected body", string(raw))
+	}
+}
+
+func TestBuildIndexCRLF(t *testing.T) {
+	x, err := BuildIndex(strings.NewReader(crlfTest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 2 {
+		t.Fatal("expecting 2 messages, got", x.Len())
+	}
+	for i, body := range []string{">From the start\r\n\r\nbody\r\n\r\n", "two\r\n\r\n"} {
+		e := x.Entries[i]
+		if raw := crlfTest[e.BodyOffset:e.End]; raw != body {
+			t.Errorf("unexpected body %q at %d", raw, e.BodyOffset)
+		}
+	}
+}
+
+func TestIndexWriteRead(t *testing.T) {
+	x, err := BuildIndex(strings.NewReader(indexTest1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	n, err := x.WriteTo(&b)
+	if err != nil {
+		t.Error(err)
+	}
+	if n != int64(b.Len()) {
+		t.Error("unexpected length", n)
+	}
+	y, err := ReadIndex(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y.Len() != 2 || y.Size != x.Size {
+		t.Fatal("unexpected index", y)
+	}
+	for i := range x.Entries {
+		a, b := x.Entries[i], y.Entries[i]
+		if a.Offset != b.Offset || a.BodyOffset != b.BodyOffset || a.End != b.End || a.MessageID != b.MessageID ||
+			a.Envelope.Raw != b.Envelope.Raw || !a.Envelope.Date.Equal(b.Envelope.Date) || b.Envelope.Offset != a.Offset {
+			t.Error("unexpected entry", b)
+		}
+	}
+	// a truncated index
+	if _, err = ReadIndex(bytes.NewReader(b.Bytes()[:b.Len()-3])); err != InvalidFormat {
+		t.Error("expecting InvalidFormat, got", err)
+	}
+}
+
+func TestIndexFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	if err := os.WriteFile(path, []byte(indexTest1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	x, err := IndexFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path + IndexSuffix); err != nil {
+		t.Error("expecting the index file", err)
+	}
+	// append a message, the index should be updated
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	_, _ = f.WriteString("From test3@example.com Wed Jan 27 02:32:24 2021\nSubject: three\n\n")
+	_ = f.Close()
+	if x, err = IndexFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if x.Len() != 3 || x.Entries[2].Offset != int64(len(indexTest1)) {
+		t.Error("unexpected index", x.Entries)
+	}
+}
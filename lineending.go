@@ -0,0 +1,79 @@
+package mbox
+
+import "bytes"
+
+// LineEnding is the line ending policy of a reader or writer, see WithLineEnding
+type LineEnding int
+
+const (
+	// LineEndingKeep keeps the line endings as they are
+	LineEndingKeep LineEnding = iota
+	// LineEndingLF converts "\r\n" to "\n"
+	LineEndingLF
+	// LineEndingCRLF converts "\n" to "\r\n", such as for SMTP
+	LineEndingCRLF
+)
+
+// carriageReturn comes before newLine in a "\r\n" line ending
+const carriageReturn = '\r'
+
+// blankLine returns true if line starts with a blank line, "\n" or "\r\n"
+func blankLine(line []byte) bool {
+	return len(line) > 0 && line[0] == newLine || len(line) > 1 && line[0] == carriageReturn && line[1] == newLine
+}
+
+// headerEnd returns the index of the "\n" that's followed by the first blank line of data, and the index
+// after the blank line. -1 is returned if there's none
+func headerEnd(data []byte) (h, body int) {
+	h = bytes.Index(data, []byte{newLine, newLine})
+	body = h + 2
+	if i := bytes.Index(data, []byte{newLine, carriageReturn, newLine}); i != -1 && (h == -1 || i < h) {
+		h, body = i, i+3
+	}
+	if h == -1 {
+		return -1, -1
+	}
+	return h, body
+}
+
+// WithLineEnding sets the line endings of the messages read by a reader, or written by a writer.
+// The default is LineEndingKeep. A writer with LineEndingCRLF also ends the "From " lines and the blank
+// lines between the messages with "\r\n". "\r\n" line endings are recognised by the reader in any case
+func WithLineEnding(le LineEnding) Option {
+	return func(c *config) {
+		c.lineEnding = le
+	}
+}
+
+// toLF appends b to dst, with "\r\n" converted to "\n". cr is true if a "\r" was held back at the end of the
+// previous call, it's updated for the next call. If end is true, there's nothing after b, and a "\r" is not
+// held back
+func toLF(dst, b []byte, cr *bool, end bool) []byte {
+	for _, c := range b {
+		if *cr && c != newLine {
+			dst = append(dst, carriageReturn)
+		}
+		*cr = c == carriageReturn
+		if !*cr {
+			dst = append(dst, c)
+		}
+	}
+	if end && *cr {
+		dst = append(dst, carriageReturn)
+		*cr = false
+	}
+	return dst
+}
+
+// toCRLF appends b to dst, with "\n" converted to "\r\n" unless it already follows a "\r". cr is true if the
+// last byte of the previous call was a "\r", it's updated for the next call
+func toCRLF(dst, b []byte, cr *bool) []byte {
+	for _, c := range b {
+		if c == newLine && !*cr {
+			dst = append(dst, carriageReturn)
+		}
+		dst = append(dst, c)
+		*cr = c == carriageReturn
+	}
+	return dst
+}
@@ -0,0 +1,129 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToLF(t *testing.T) {
+	var cr bool
+	var out []byte
+	for _, s := range []string{"a\r\nb\r", "\nc\r", "d\r"} {
+		out = toLF(out, []byte(s), &cr, false)
+	}
+	out = toLF(out, nil, &cr, true)
+	if string(out) != "a\nb\nc\rd\r" {
+		t.Errorf("unexpected result %q", out)
+	}
+}
+
+func TestToCRLF(t *testing.T) {
+	var cr bool
+	var out []byte
+	for _, s := range []string{"a\nb\r", "\nc\n"} {
+		out = toCRLF(out, []byte(s), &cr)
+	}
+	if string(out) != "a\r\nb\r\nc\r\n" {
+		t.Errorf("unexpected result %q", out)
+	}
+}
+
+const crlfTest = "From a@example.com Wed Jan 27 02:32:22 2021\r\nSubject: one\r\n\r\n>From the start\r\n\r\nbody\r\n\r\n" +
+	"From b@example.com Wed Jan 27 02:32:23 2021\r\nSubject: two\r\n\r\ntwo\r\n\r\n"
+
+// readBodies returns the bodies of the messages in in, and checks the envelopes
+func readBodies(t *testing.T, in string, opts ...Option) []string {
+	s := NewScanner(strings.NewReader(in), opts...)
+	var bodies []string
+	for s.Next() {
+		if e := s.Message().Envelope; e.Date.IsZero() || strings.HasSuffix(e.Raw, "\r") {
+			t.Error("unexpected envelope", e)
+		}
+		b, err := io.ReadAll(s.Message().Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, string(b))
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	return bodies
+}
+
+func TestReadCRLF(t *testing.T) {
+	crlf := []string{"Subject: one\r\n\r\nFrom the start\r\n\r\nbody\r\n", "Subject: two\r\n\r\ntwo\r\n"}
+	lf := []string{"Subject: one\n\nFrom the start\n\nbody\n", "Subject: two\n\ntwo\n"}
+	if bodies := readBodies(t, crlfTest); !slices.Equal(bodies, crlf) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+	if bodies := readBodies(t, crlfTest, WithLineEnding(LineEndingLF)); !slices.Equal(bodies, lf) {
+		t.Errorf("unexpected bodies with LineEndingLF %q", bodies)
+	}
+	in := strings.ReplaceAll(crlfTest, "\r\n", "\n")
+	if bodies := readBodies(t, in, WithLineEnding(LineEndingCRLF)); !slices.Equal(bodies, crlf) {
+		t.Errorf("unexpected bodies with LineEndingCRLF %q", bodies)
+	}
+}
+
+func TestReadCRLFSmallBuffer(t *testing.T) {
+	buf := make([]byte, 3)
+	var b bytes.Buffer
+	r := NewReader(strings.NewReader(crlfTest), WithLineEnding(LineEndingLF))
+	_, err := io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Subject: one\n\nFrom the start\n\nbody\n" {
+		t.Errorf("unexpected result %q", b.String())
+	}
+}
+
+func TestReadCRLFContentLength(t *testing.T) {
+	in := "From a@example.com Wed Jan 27 02:32:22 2021\r\nContent-Length: 18\r\n\r\n" +
+		"From the start\r\n\r\n\r\nFrom b@example.com Wed Jan 27 02:32:23 2021\r\n\r\ntwo\r\n\r\n"
+	bodies := readBodies(t, in, WithVariant(MboxCL2))
+	if !slices.Equal(bodies, []string{"Content-Length: 18\r\n\r\nFrom the start\r\n\r\n", "\r\ntwo\r\n"}) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+}
+
+func TestWriteLineEnding(t *testing.T) {
+	date := time.Date(2021, 1, 27, 2, 32, 22, 0, time.UTC)
+	tests := []struct {
+		le       LineEnding
+		variant  Variant
+		in       string
+		expected string
+	}{
+		{LineEndingLF, MboxRD, "Subject: one\r\n\r\nFrom the start\r", "From a@example.com Wed Jan 27 02:32:22 2021\n" +
+			"Subject: one\n\n>From the start\r\n"},
+		{LineEndingCRLF, MboxRD, "Subject: one\n\nFrom the start\r\n", "From a@example.com Wed Jan 27 02:32:22 2021\r\n" +
+			"Subject: one\r\n\r\n>From the start\r\n\r\n"},
+		{LineEndingCRLF, MboxCL2, "Subject: one\n\nFrom the start\n", "From a@example.com Wed Jan 27 02:32:22 2021\r\n" +
+			"Subject: one\r\nContent-Length: 16\r\n\r\nFrom the start\r\n\r\n"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		w := NewWriter(&b, WithLineEnding(test.le), WithVariant(test.variant))
+		if err := w.Open("a@example.com", date); err != nil {
+			t.Error(err)
+		}
+		// write one byte at a time, to split the line endings
+		for i := range test.in {
+			if _, err := w.Write([]byte{test.in[i]}); err != nil {
+				t.Error(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+		if b.String() != test.expected {
+			t.Errorf("unexpected result %q", b.String())
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// LockMethod is a way of locking a mailbox file, see WithLocks. The methods can be combined
+type LockMethod uint8
+
+const (
+	// LockDotlock creates a lock file next to the mailbox, named after it with DotlockSuffix
+	LockDotlock LockMethod = 1 << iota
+	// LockFcntl takes a POSIX fcntl write lock on the mailbox
+	LockFcntl
+	// LockFlock takes a BSD flock exclusive lock on the mailbox
+	LockFlock
+)
+
+// DefaultLocks are the locks taken by AppendFile unless WithLocks is given. They're the locks procmail,
+// postfix local, mutt and dovecot take by default on Linux
+const DefaultLocks = LockDotlock | LockFcntl
+
+// DotlockSuffix is added to the path of a mailbox for the path of its lock file
+const DotlockSuffix = ".lock"
+
+// closeTailWindow is how much of the end of a mailbox AppendFile reads to find its last message
+const closeTailWindow = 4 * 1024 * 1024
+
+// LockTimeout is returned by AppendFile if the locks could not be taken in time
+var LockTimeout = errors.New("timeout waiting for the mailbox lock")
+
+const (
+	// defaultLockTimeout is how long AppendFile waits for the locks, unless WithLockTimeout is given
+	defaultLockTimeout = 30 * time.Second
+	// lockRetry is the time between attempts to take a lock
+	lockRetry = 100 * time.Millisecond
+	// staleLock is the age of a lock file that was left behind by a process that died
+	staleLock = 5 * time.Minute
+)
+
+// WithLocks sets the locks taken by AppendFile. The default is DefaultLocks, 0 takes no locks
+func WithLocks(m LockMethod) Option {
+	return func(c *config) {
+		c.locks = m
+	}
+}
+
+// WithLockTimeout sets how long AppendFile waits for the locks. The default is 30 seconds
+func WithLockTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.lockTimeout = d
+	}
+}
+
+// lockedFile is a mailbox file that's unlocked when it's closed
+type lockedFile struct {
+	*os.File
+	// dotlock is the path of the lock file, if one was created
+	dotlock string
+	// touched is when the lock file was last touched, so that it's not taken as stale
+	touched time.Time
+	// locks are the kernel locks that are held
+	locks LockMethod
+}
+
+// AppendFile opens the mailbox at path for appending, creating it if it doesn't exist, and takes the locks
+// given by WithLocks, in the order dotlock, fcntl, flock. The locks are released by Close of the returned
+// MailboxWriter. LockTimeout is returned if a lock is held by another process for longer than the timeout
+// given by WithLockTimeout. A lock file older than 5 minutes is taken as stale, and removed.
+// If the last message is not followed by a blank line, the blank line is added first, so that the messages
+// are not merged
+func AppendFile(path string, opts ...Option) (*MailboxWriter, error) {
+	f, err := appendFile(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewMailboxWriter(f, opts...), nil
+}
+
+// appendFile opens the mailbox at path for appending and takes the locks, like AppendFile
+func appendFile(path string, opts []Option) (*lockedFile, error) {
+	f, err := lockFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, newConfig(opts))
+	if err != nil {
+		return nil, err
+	}
+	if err = closeTail(f, opts); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// closeTail adds the blank line after the last message of f, if it's missing. An incomplete last message is
+// kept, Repair is what removes it. Nothing is done if the end of f is not a message, or if the last message
+// doesn't begin in the last closeTailWindow bytes
+func closeTail(f *lockedFile, opts []Option) error {
+	c := newConfig(opts)
+	if !hasEnvelope(c.variant) {
+		return nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	rep, err := checkTail(f, fi.Size(), c, closeTailWindow)
+	if errors.Is(err, InvalidFormat) || errors.Is(err, errTailTooLong) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var tail string
+	switch rep.Action {
+	case RepairClosed:
+		tail = "\r\n"[2-rep.Added:]
+	case RepairTruncated:
+		last := make([]byte, min(fi.Size(), 3))
+		if _, err = f.ReadAt(last, fi.Size()-int64(len(last))); err != nil {
+			return err
+		}
+		le := "\n"
+		if bytes.HasSuffix(last, []byte{carriageReturn, newLine}) {
+			le = "\r\n"
+		}
+		switch {
+		case bytes.HasSuffix(last, []byte{newLine, newLine}) || bytes.HasSuffix(last, []byte{newLine, carriageReturn, newLine}):
+		case last[len(last)-1] == newLine:
+			tail = le
+		default:
+			tail = le + le
+		}
+	}
+	if tail == "" {
+		return nil
+	}
+	_, err = f.Write([]byte(tail))
+	return err
+}
+
+// lockFile opens the mailbox at path with flag, and takes the locks given by c
+func lockFile(path string, flag int, c config) (*lockedFile, error) {
+	deadline := time.Now().Add(c.lockTimeout)
+	lf := new(lockedFile)
+	if c.locks&LockDotlock != 0 {
+		lock := path + DotlockSuffix
+		if err := retry(deadline, func() (bool, error) { return dotlock(lock) }); err != nil {
+			return nil, err
+		}
+		lf.dotlock = lock
+		lf.touched = time.Now()
+	}
+	f, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		_ = lf.Close()
+		return nil, err
+	}
+	lf.File = f
+	for _, l := range []struct {
+		method LockMethod
+		lock   func(f *os.File) (bool, error)
+	}{{LockFcntl, fcntlLock}, {LockFlock, flockLock}} {
+		if c.locks&l.method == 0 {
+			continue
+		}
+		if err = retry(deadline, func() (bool, error) { return l.lock(f) }); err != nil {
+			_ = lf.Close()
+			return nil, err
+		}
+		lf.locks |= l.method
+	}
+	return lf, nil
+}
+
+// retry calls try until it returns true or an error. LockTimeout is returned if deadline passes
+func retry(deadline time.Time, try func() (bool, error)) error {
+	for {
+		ok, err := try()
+		if ok || err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return LockTimeout
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// dotlock creates the lock file at path, with the pid in it. It returns false if the lock file exists, and
+// removes it if it's stale
+func dotlock(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = fmt.Fprintf(f, "%d\n", os.Getpid())
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(path)
+		}
+		return err == nil, err
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return false, err
+	}
+	if fi, err := os.Stat(path); err == nil && time.Since(fi.ModTime()) > staleLock {
+		if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Write implements io.Writer, the lock file is touched if it's getting old
+func (f *lockedFile) Write(p []byte) (int, error) {
+	if f.dotlock != "" && time.Since(f.touched) > staleLock/2 {
+		now := time.Now()
+		if err := os.Chtimes(f.dotlock, now, now); err != nil {
+			return 0, err
+		}
+		f.touched = now
+	}
+	return f.File.Write(p)
+}
+
+// Close releases the locks and closes the file
+func (f *lockedFile) Close() error {
+	var err error
+	if f.File != nil {
+		if f.locks&LockFlock != 0 {
+			err = flockUnlock(f.File)
+		}
+		if f.locks&LockFcntl != 0 {
+			if uerr := fcntlUnlock(f.File); err == nil {
+				err = uerr
+			}
+		}
+		if cerr := f.File.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if f.dotlock != "" {
+		if rerr := os.Remove(f.dotlock); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
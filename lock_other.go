@@ -0,0 +1,28 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package mbox
+
+import (
+	"errors"
+	"os"
+)
+
+// fcntlLock is not supported on this platform
+func fcntlLock(f *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// fcntlUnlock is not supported on this platform
+func fcntlUnlock(f *os.File) error {
+	return errors.ErrUnsupported
+}
+
+// flockLock is not supported on this platform
+func flockLock(f *os.File) (bool, error) {
+	return false, errors.ErrUnsupported
+}
+
+// flockUnlock is not supported on this platform
+func flockUnlock(f *os.File) error {
+	return errors.ErrUnsupported
+}
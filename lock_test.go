@@ -0,0 +1,140 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// appendMessage appends a message to the mailbox at path with AppendFile, and returns its offset
+func appendMessage(t *testing.T, path, body string, opts ...Option) int64 {
+	w, err := AppendFile(path, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.BeginMessage(Envelope{From: "a@example.com", Date: time.Date(2021, 1, 27, 2, 32, 22, 0, time.UTC)}); err != nil {
+		t.Error(err)
+	}
+	if _, err = io.WriteString(w, body); err != nil {
+		t.Error(err)
+	}
+	offset := w.Envelope().Offset
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	return offset
+}
+
+func TestAppendFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	if offset := appendMessage(t, path, "Subject: one\n\none\n"); offset != 0 {
+		t.Error("unexpected offset", offset)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset := appendMessage(t, path, "Subject: two\n\ntwo\n", WithLocks(LockDotlock|LockFcntl|LockFlock)); offset != fi.Size() {
+		t.Error("unexpected offset", offset)
+	}
+	if _, err = os.Stat(path + DotlockSuffix); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("the lock file should be removed", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodies := readBodies(t, string(data)); !slices.Equal(bodies, []string{"Subject: one\n\none\n", "Subject: two\n\ntwo\n"}) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+}
+
+func TestAppendFileCloseTail(t *testing.T) {
+	const from = "From b@example.com Wed Jan 27 02:32:22 2021\n"
+	path := filepath.Join(t.TempDir(), "mbox")
+	for _, test := range []struct {
+		in, expected string
+	}{
+		{"", ""},
+		{from + "Subject: one\n\none\n\n", from + "Subject: one\n\none\n\n"},
+		{from + "Subject: one\n\none\n", from + "Subject: one\n\none\n\n"},
+		{from + "Subject: one\n\nno trailing blank", from + "Subject: one\n\nno trailing blank\n\n"},
+		{from + "Subject: one\r\n\r\none\r\n", from + "Subject: one\r\n\r\none\r\n\r\n"},
+	} {
+		if err := os.WriteFile(path, []byte(test.in), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if offset := appendMessage(t, path, "Subject: two\n\ntwo\n"); offset != int64(len(test.expected)) {
+			t.Error("unexpected offset", offset)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), test.expected+"From a@example.com ") {
+			t.Errorf("unexpected mailbox %q", data)
+		}
+		if bodies := readBodies(t, string(data)); len(bodies) != 1+min(len(test.in), 1) {
+			t.Errorf("unexpected bodies %q", bodies)
+		}
+	}
+}
+
+// the end of a last message that's too long to read is left as it is
+func TestAppendFileCloseTailLong(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	in := "From b@example.com Wed Jan 27 02:32:22 2021\nSubject: one\n\n" + strings.Repeat("long\n", closeTailWindow/5+1)
+	if err := os.WriteFile(path, []byte(in), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if offset := appendMessage(t, path, "Subject: two\n\ntwo\n"); offset != int64(len(in)) {
+		t.Error("unexpected offset", offset)
+	}
+}
+
+func TestAppendFileDotlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	if err := os.WriteFile(path+DotlockSuffix, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AppendFile(path, WithLockTimeout(200*time.Millisecond)); !errors.Is(err, LockTimeout) {
+		t.Error("expecting LockTimeout, got", err)
+	}
+	// a stale lock file is removed
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path+DotlockSuffix, old, old); err != nil {
+		t.Fatal(err)
+	}
+	appendMessage(t, path, "Subject: one\n\none\n", WithLockTimeout(time.Second))
+	if _, err := os.Stat(path + DotlockSuffix); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("the lock file should be removed", err)
+	}
+}
+
+func TestAppendFileFlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if ok, err := flockLock(f); !ok {
+		t.Skip("flock is not supported", err)
+	}
+	if _, err = AppendFile(path, WithLocks(LockDotlock|LockFlock), WithLockTimeout(200*time.Millisecond)); !errors.Is(err, LockTimeout) {
+		t.Error("expecting LockTimeout, got", err)
+	}
+	if _, err = os.Stat(path + DotlockSuffix); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("the lock file should be removed", err)
+	}
+	if err = flockUnlock(f); err != nil {
+		t.Error(err)
+	}
+	appendMessage(t, path, "Subject: one\n\none\n", WithLocks(LockFlock))
+}
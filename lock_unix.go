@@ -0,0 +1,39 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package mbox
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// fcntlLock takes an fcntl write lock on all of f. It returns false if another process holds a lock
+func fcntlLock(f *os.File) (bool, error) {
+	lk := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk)
+	if err == syscall.EAGAIN || err == syscall.EACCES {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// fcntlUnlock releases the fcntl lock on f
+func fcntlUnlock(f *os.File) error {
+	lk := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: io.SeekStart}
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lk)
+}
+
+// flockLock takes an exclusive flock lock on f. It returns false if another process holds a lock
+func flockLock(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// flockUnlock releases the flock lock on f
+func flockUnlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
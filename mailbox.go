@@ -0,0 +1,193 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// NoMessage is returned by MailboxWriter when a message is written before BeginMessage
+var NoMessage = errors.New("no message was begun")
+
+// MailboxWriter writes many messages to a mailbox in one session.
+// Unlike the writers returned by NewWriter, NewMMDFWriter and NewBabylWriter, ending a message doesn't close
+// the underlying writer, only Close does.
+// If the underlying writer is a file, such as with AppendFile, each message is a transaction: the size of the
+// file is recorded when the message begins, and the file is truncated back to it if writing the message fails,
+// or if it's abandoned with Abort
+type MailboxWriter struct {
+	w  io.Writer
+	mw messageWriter
+	// open is true between BeginMessage and EndMessage
+	open bool
+	// last is the last byte written to the current message, written is true if there's one
+	last    byte
+	written bool
+	// base is the offset of w in the mailbox, it's the size of the file when a message begins less begin
+	base int64
+	// begin is the offset of mw when the current message began, and size the size of the file, or -1 if w
+	// can't be truncated
+	begin int64
+	size  int64
+
+	config
+}
+
+// truncater is implemented by *os.File, for rolling back a message
+type truncater interface {
+	io.Seeker
+	Stat() (fs.FileInfo, error)
+	Truncate(size int64) error
+}
+
+// unclosed hides the Close method of the underlying writer from the encoders, Stat is still used by Babyl
+type unclosed struct {
+	io.Writer
+}
+
+// Stat returns the FileInfo of the underlying writer, if it's a file
+func (u unclosed) Stat() (fs.FileInfo, error) {
+	return stat(u.Writer)
+}
+
+// stat returns the FileInfo of w, if it's a file
+func stat(w io.Writer) (fs.FileInfo, error) {
+	if f, ok := w.(interface{ Stat() (fs.FileInfo, error) }); ok {
+		return f.Stat()
+	}
+	return nil, errors.ErrUnsupported
+}
+
+// NewMailboxWriter returns a MailboxWriter, ready to write messages to w.
+// Use WithVariant to write formats other than mboxrd, including MMDF and Babyl
+func NewMailboxWriter(w io.Writer, opts ...Option) *MailboxWriter {
+	return &MailboxWriter{w: w, mw: newMessageWriter(unclosed{w}, opts...), config: newConfig(opts)}
+}
+
+// WithSync makes MailboxWriter sync the file to stable storage after each message, so that a message
+// is not lost once EndMessage returns
+func WithSync() Option {
+	return func(c *config) {
+		c.sync = true
+	}
+}
+
+// BeginMessage begins a new message with the envelope e, the current message is ended first
+func (m *MailboxWriter) BeginMessage(e Envelope) error {
+	if m.open {
+		if err := m.EndMessage(); err != nil {
+			return err
+		}
+	}
+	m.begin, m.size = m.mw.offset(), -1
+	if f, ok := m.w.(truncater); ok {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		m.size = fi.Size()
+		m.base = m.size - m.begin
+	}
+	m.open = true
+	m.written = false
+	if err := m.mw.OpenEnvelope(e); err != nil {
+		return m.rollback(err)
+	}
+	return nil
+}
+
+// Write implements io.Writer, p is a part of the current message.
+// NoMessage is returned if BeginMessage was not called
+func (m *MailboxWriter) Write(p []byte) (int, error) {
+	if !m.open {
+		return 0, NoMessage
+	}
+	n, err := m.mw.Write(p)
+	if n > 0 {
+		m.last = p[n-1]
+		m.written = true
+	}
+	if err != nil {
+		return n, m.rollback(err)
+	}
+	return n, nil
+}
+
+// Envelope returns the envelope of the current message, with its offset
+func (m *MailboxWriter) Envelope() Envelope {
+	e := m.mw.Envelope()
+	e.Offset += m.base
+	return e
+}
+
+// offset returns the offset of the end of the mailbox
+func (m *MailboxWriter) offset() int64 {
+	return m.base + m.mw.offset()
+}
+
+// EndMessage ends the current message. If the message doesn't end with a new line, one is added, so that
+// the next message is preceded by a blank line. NoMessage is returned if BeginMessage was not called
+func (m *MailboxWriter) EndMessage() error {
+	if !m.open {
+		return NoMessage
+	}
+	if m.written && m.last != newLine {
+		if _, err := m.mw.Write(eol); err != nil {
+			return m.rollback(err)
+		}
+	}
+	if err := m.mw.Close(); err != nil {
+		return m.rollback(err)
+	}
+	if s, ok := m.w.(interface{ Sync() error }); ok && m.sync {
+		if err := s.Sync(); err != nil {
+			return m.rollback(err)
+		}
+	}
+	m.open = false
+	return nil
+}
+
+// Abort abandons the current message. The file is truncated to the size it had before the message began,
+// errors.ErrUnsupported is returned if the underlying writer is not a file.
+// NoMessage is returned if BeginMessage was not called
+func (m *MailboxWriter) Abort() error {
+	if !m.open {
+		return NoMessage
+	}
+	return m.rollback(nil)
+}
+
+// rollback abandons the current message after err, and truncates the file. err is returned, together with
+// any error from the truncation
+func (m *MailboxWriter) rollback(err error) error {
+	m.open = false
+	m.mw.rollback(m.begin)
+	if m.size == -1 {
+		if err == nil {
+			return errors.ErrUnsupported
+		}
+		return err
+	}
+	f := m.w.(truncater)
+	terr := f.Truncate(m.size)
+	if terr == nil {
+		// the file may not be in append mode
+		_, terr = f.Seek(m.size, io.SeekStart)
+	}
+	return errors.Join(err, terr)
+}
+
+// Close ends the current message, if there's one, and closes the underlying writer if it's an io.Closer
+func (m *MailboxWriter) Close() error {
+	var err error
+	if m.open {
+		err = m.EndMessage()
+	}
+	if closer, ok := m.w.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailboxWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2021, 1, 27, 2, 32, 22, 0, time.UTC)
+	w := NewMailboxWriter(f)
+	if _, err = w.Write([]byte("no message")); !errors.Is(err, NoMessage) {
+		t.Error("expecting NoMessage, got", err)
+	}
+	for _, body := range []string{"Subject: one\n\nFrom the start\n", "Subject: two\n\nno new line", "Subject: three\n\n"} {
+		if err = w.BeginMessage(Envelope{From: "a@example.com", Date: date}); err != nil {
+			t.Error(err)
+		}
+		if _, err = io.WriteString(w, body); err != nil {
+			t.Error(err)
+		}
+	}
+	if w.Envelope().Offset != 146 {
+		t.Error("unexpected offset", w.Envelope().Offset)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = w.EndMessage(); !errors.Is(err, NoMessage) {
+		t.Error("expecting NoMessage, got", err)
+	}
+	if _, err = f.Write([]byte{newLine}); err == nil {
+		t.Error("the file should be closed")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := readBodies(t, string(data))
+	if !slices.Equal(bodies, []string{"Subject: one\n\nFrom the start\n", "Subject: two\n\nno new line\n", "Subject: three\n\n"}) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+}
+
+func TestMailboxWriterBabylAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "babyl")
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := NewMailboxWriter(f, WithVariant(Babyl))
+		if err = w.BeginMessage(Envelope{}); err != nil {
+			t.Error(err)
+		}
+		if _, err = io.WriteString(w, "Subject: test\n\nbody\n"); err != nil {
+			t.Error(err)
+		}
+		if err = w.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := NewScanner(f, WithVariant(Babyl))
+	n := 0
+	for s.Next() {
+		n++
+	}
+	if s.Err() != nil || n != 2 {
+		t.Error("expecting 2 messages, got", n, s.Err())
+	}
+}
+
+// failingFile fails once to write after fail bytes
+type failingFile struct {
+	*os.File
+	fail int
+}
+
+var errWrite = errors.New("write failed")
+
+// Write implements io.Writer
+func (f *failingFile) Write(p []byte) (int, error) {
+	if len(p) > f.fail {
+		n, _ := f.File.Write(p[:f.fail])
+		f.fail = math.MaxInt
+		return n, errWrite
+	}
+	f.fail -= len(p)
+	return f.File.Write(p)
+}
+
+func TestMailboxWriterRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff := &failingFile{File: f, fail: 100}
+	w := NewMailboxWriter(ff, WithSync())
+	e := Envelope{From: "a@example.com", Date: time.Date(2021, 1, 27, 2, 32, 22, 0, time.UTC)}
+	var offsets []int64
+	for i, body := range []string{"Subject: one\n\none\n", "Subject: two\n\n" + strings.Repeat("two\n", 20), "Subject: three\n\nthree\n"} {
+		if err = w.BeginMessage(e); err != nil {
+			t.Error(err)
+		}
+		offsets = append(offsets, w.Envelope().Offset)
+		_, err = io.WriteString(w, body)
+		if i == 1 {
+			if !errors.Is(err, errWrite) {
+				t.Error("expecting the write error, got", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+		}
+		if err = w.EndMessage(); err != nil {
+			t.Error(err)
+		}
+	}
+	// a message that's abandoned
+	if err = w.BeginMessage(e); err != nil {
+		t.Error(err)
+	}
+	if _, err = io.WriteString(w, "Subject: four\n\nfour\n"); err != nil {
+		t.Error(err)
+	}
+	if err = w.Abort(); err != nil {
+		t.Error(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	if !slices.Equal(offsets, []int64{0, 63, 63}) {
+		t.Error("unexpected offsets", offsets)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bodies := readBodies(t, string(data)); !slices.Equal(bodies, []string{"Subject: one\n\none\n", "Subject: three\n\nthree\n"}) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+}
+
+func TestMailboxWriterAbort(t *testing.T) {
+	var b bytes.Buffer
+	w := NewMailboxWriter(&b)
+	if err := w.Abort(); !errors.Is(err, NoMessage) {
+		t.Error("expecting NoMessage, got", err)
+	}
+	if err := w.BeginMessage(Envelope{From: "a@example.com"}); err != nil {
+		t.Error(err)
+	}
+	if err := w.Abort(); !errors.Is(err, errors.ErrUnsupported) {
+		t.Error("expecting ErrUnsupported, got", err)
+	}
+}
@@ -0,0 +1,217 @@
+// Package maildir moves messages between mbox streams and Maildir directories.
+// Message flags are kept in the ":2," info of the file names, and in the Status and X-Status fields of mbox
+package maildir
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/flashmob/mbox"
+)
+
+// info starts the flags of a file name in cur
+const info = ":2,"
+
+// flagLetters map the Maildir flags to the mbox flags, in the order they are written
+var flagLetters = []struct {
+	c byte
+	f mbox.Flags
+}{{'D', mbox.Draft}, {'F', mbox.Flagged}, {'R', mbox.Answered}, {'S', mbox.Seen}, {'T', mbox.Deleted}}
+
+// deliveries counts the deliveries of this process, for unique file names
+var deliveries int64
+
+// Create creates the tmp, new and cur directories of the Maildir at dir, if they don't exist
+func Create(dir string) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flags returns the mbox flags of a file name. Files in cur are Old
+func Flags(name string, cur bool) mbox.Flags {
+	var f mbox.Flags
+	if cur {
+		f |= mbox.Old
+	}
+	i := strings.LastIndex(name, info)
+	if i == -1 {
+		return f
+	}
+	for _, l := range flagLetters {
+		if strings.IndexByte(name[i+len(info):], l.c) != -1 {
+			f |= l.f
+		}
+	}
+	return f
+}
+
+// Info returns the ":2," info of f, for a file name in cur
+func Info(f mbox.Flags) string {
+	var sb strings.Builder
+	sb.WriteString(info)
+	for _, l := range flagLetters {
+		if f&l.f != 0 {
+			sb.WriteByte(l.c)
+		}
+	}
+	return sb.String()
+}
+
+// uniqueName returns a unique file name for a delivery at t
+func uniqueName(t time.Time) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", t.Unix(), t.Nanosecond()/1000, os.Getpid(), atomic.AddInt64(&deliveries, 1), host)
+}
+
+// Deliver writes a message to the Maildir at dir, first to tmp then moved to new, or to cur if any flags
+// are set. The file modification time is set to date, if it's not zero.
+// The path of the message is returned
+func Deliver(dir string, r io.Reader, f mbox.Flags, date time.Time) (string, error) {
+	name := uniqueName(time.Now())
+	tmp := filepath.Join(dir, "tmp", name)
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(file, r); err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !date.IsZero() {
+		err = os.Chtimes(tmp, date, date)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	path := filepath.Join(dir, "new", name)
+	if f != 0 {
+		path = filepath.Join(dir, "cur", name+Info(f))
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// Import delivers each message read from the mbox stream r to the Maildir at dir, which is created if needed.
+// The flags are taken from the Status and X-Status fields, and the modification times from the "From " lines.
+// The number of messages imported is returned
+func Import(dir string, r io.Reader, opts ...mbox.Option) (int, error) {
+	if err := Create(dir); err != nil {
+		return 0, err
+	}
+	s := mbox.NewScanner(r, opts...)
+	n := 0
+	for s.Next() {
+		m := s.Message()
+		br := bufio.NewReader(m.Body)
+		header, err := mbox.ReadHeader(br)
+		if err != nil {
+			return n, err
+		}
+		body := io.MultiReader(bytes.NewReader(header), br)
+		if _, err = Deliver(dir, body, mbox.HeaderFlags(header), m.Envelope.Date); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, s.Err()
+}
+
+// message is a file of the Maildir
+type message struct {
+	path    string
+	cur     bool
+	modTime time.Time
+}
+
+// messages returns the files in new and cur, in order of their modification time
+func messages(dir string) ([]message, error) {
+	var list []message
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(dir, sub))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			fi, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, message{path: filepath.Join(dir, sub, e.Name()), cur: sub == "cur", modTime: fi.ModTime()})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if !list[i].modTime.Equal(list[j].modTime) {
+			return list[i].modTime.Before(list[j].modTime)
+		}
+		return filepath.Base(list[i].path) < filepath.Base(list[j].path)
+	})
+	return list, nil
+}
+
+// Export writes each message of the Maildir at dir to w, in order of their modification time.
+// The "From " lines are built from the Return-Path or Delivered-To fields and the modification times,
+// and the flags are written to the Status and X-Status fields. mboxrd is written, unless another mbox variant is
+// given in opts. The number of messages exported is returned
+func Export(w io.Writer, dir string, opts ...mbox.Option) (int, error) {
+	list, err := messages(dir)
+	if err != nil {
+		return 0, err
+	}
+	enc := mbox.NewMailboxWriter(w, opts...)
+	n := 0
+	for _, m := range list {
+		if err = export(enc, m); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// export writes the message m to enc
+func export(enc *mbox.MailboxWriter, m message) error {
+	file, err := os.Open(m.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	header, err := mbox.ReadHeader(br)
+	if err != nil {
+		return err
+	}
+	e := mbox.Envelope{From: mbox.HeaderEnvelope(header).From, Date: m.modTime}
+	if err = enc.BeginMessage(e); err != nil {
+		return err
+	}
+	header = mbox.SetHeaderFlags(header, Flags(filepath.Base(m.path), m.cur))
+	if _, err = io.Copy(enc, io.MultiReader(bytes.NewReader(header), br)); err != nil {
+		return err
+	}
+	return enc.EndMessage()
+}
@@ -0,0 +1,74 @@
+package maildir
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flashmob/mbox"
+)
+
+const test1 = `From test@example.com Wed Jan 27 02:32:22 2021
+Subject: one
+Status: RO
+X-Status: F
+
+>From the start
+
+From test2@example.com Wed Jan 27 02:32:23 2021
+Return-Path: <test2@example.com>
+Subject: two
+
+new message
+
+`
+
+func TestFlags(t *testing.T) {
+	if f := Flags("1611714742.M1P2Q3.host:2,FS", true); f != mbox.Old|mbox.Flagged|mbox.Seen {
+		t.Error("unexpected flags", f)
+	}
+	if f := Flags("1611714742.M1P2Q3.host", false); f != 0 {
+		t.Error("unexpected flags", f)
+	}
+	if i := Info(mbox.Old | mbox.Seen | mbox.Answered | mbox.Draft); i != ":2,DRS" {
+		t.Error("unexpected info", i)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	dir := t.TempDir()
+	n, err := Import(dir, strings.NewReader(test1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Error("expecting 2 messages, got", n)
+	}
+	cur, _ := os.ReadDir(filepath.Join(dir, "cur"))
+	if len(cur) != 1 || !strings.HasSuffix(cur[0].Name(), ":2,FS") {
+		t.Error("expecting a seen and flagged message in cur", cur)
+	}
+	newMsgs, _ := os.ReadDir(filepath.Join(dir, "new"))
+	if len(newMsgs) != 1 {
+		t.Fatal("expecting a message in new", newMsgs)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "new", newMsgs[0].Name()))
+	if string(data) != "Return-Path: <test2@example.com>\nSubject: two\n\nnew message\n" {
+		t.Error("unexpected message", string(data))
+	}
+
+	var b bytes.Buffer
+	if n, err = Export(&b, dir); err != nil {
+		t.Error(err)
+	}
+	if n != 2 {
+		t.Error("expecting 2 messages, got", n)
+	}
+	// the sender of the first message is unknown
+	expected := strings.Replace(test1, "From test@example.com", "From MAILER-DAEMON", 1)
+	if b.String() != expected {
+		t.Error("unexpected result", b.String())
+	}
+}
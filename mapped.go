@@ -0,0 +1,225 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"iter"
+)
+
+// Mapped reads messages from a mailbox that is in memory, such as a file mapped by OpenMapped.
+// Messages are returned as slices of the mailbox, nothing is copied unless a line has to be unescaped.
+// Only the variants with a "From " line can be read
+type Mapped struct {
+	data []byte
+	// pos is the offset of the next "From " line
+	pos int
+	// unmap releases data, if it was mapped
+	unmap func() error
+
+	config
+}
+
+// MappedMessage is a message read by Mapped, its slices are only valid until Mapped is closed
+type MappedMessage struct {
+	// Envelope is the "From " line of the message
+	Envelope Envelope
+	// Raw is the message as it is in the mailbox, between the "From " line and the blank line that
+	// separates it from the next
+	Raw []byte
+
+	variant Variant
+}
+
+// NewMapped returns a Mapped, ready to read the mailbox in data
+func NewMapped(data []byte, opts ...Option) *Mapped {
+	return &Mapped{data: data, config: newConfig(opts)}
+}
+
+// Next returns the next message. io.EOF is returned if there are no more messages
+func (m *Mapped) Next() (*MappedMessage, error) {
+	if !hasEnvelope(m.variant) {
+		return nil, InvalidFormat
+	}
+	if m.pos >= len(m.data) {
+		return nil, io.EOF
+	}
+	data := m.data[m.pos:]
+	if !bytes.HasPrefix(data, []byte(header)) {
+		return nil, InvalidFormat
+	}
+	eol := bytes.IndexByte(data, newLine)
+	if eol == -1 {
+		return nil, InvalidHeader
+	}
+	e, err := ParseEnvelope(string(data[:eol]), m.layouts...)
+	if err != nil {
+		return nil, err
+	}
+	e.Offset = int64(m.pos)
+	start := eol + 1
+	end, next := m.contentLength(data, start)
+	if end == -1 {
+		end, next = boundary(data, start)
+	}
+	m.pos += next
+	return &MappedMessage{Envelope: e, Raw: data[start:end], variant: m.variant}, nil
+}
+
+// boundary returns the end of the message that starts at start, and the offset of the next "From " line
+func boundary(data []byte, start int) (end, next int) {
+	// the eol of the "From " line may be followed by a blank line
+	for pos := start - 1; ; {
+		i := bytes.Index(data[pos:], []byte("\n"+header))
+		if i == -1 {
+			break
+		}
+		next = pos + i + 1
+		if afterBlank(data, next, 1) {
+			end = next - 1
+			if data[end-1] == carriageReturn {
+				end--
+			}
+			return max(end, start), next
+		}
+		pos = next
+	}
+	end = len(data)
+	if bytes.HasSuffix(data[start-1:], []byte{newLine, newLine}) {
+		// the blank line after the last message
+		end--
+	} else if bytes.HasSuffix(data[start-1:], []byte{newLine, carriageReturn, newLine}) {
+		end -= 2
+	}
+	return end, len(data)
+}
+
+// contentLength returns the end of the message that starts at start, and the offset of the next "From " line,
+// using the Content-Length field for mboxcl and mboxcl2. -1 is returned if there's no valid Content-Length
+func (m *Mapped) contentLength(data []byte, start int) (end, next int) {
+	if m.variant != MboxCL && m.variant != MboxCL2 {
+		return -1, -1
+	}
+	_, body := headerEnd(data[start-1:])
+	if body == -1 {
+		return -1, -1
+	}
+	body += start - 1
+	cl := int64(-1)
+	fields(data[start:body], func(field []byte) bool {
+		if n, ok := contentLength(field); ok {
+			cl = n
+		}
+		return true
+	})
+	if cl == -1 || cl > int64(len(data)-body) {
+		return -1, -1
+	}
+	end = body + int(cl)
+	switch {
+	case end == len(data):
+		return end, end
+	case !blankLine(data[end:]):
+		return -1, -1
+	}
+	next = end + 1
+	if data[end] == carriageReturn {
+		next++
+	}
+	if next == len(data) || bytes.HasPrefix(data[next:], []byte(header)) {
+		return end, next
+	}
+	return -1, -1
+}
+
+// All returns an iterator over the remaining messages.
+// If an error stops the iteration, it is yielded with a nil message as the last value
+func (m *Mapped) All() iter.Seq2[*MappedMessage, error] {
+	return func(yield func(*MappedMessage, error) bool) {
+		for {
+			msg, err := m.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(msg, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close releases the mapping, if the mailbox was opened with OpenMapped
+func (m *Mapped) Close() error {
+	m.data = nil
+	m.pos = 0
+	if m.unmap != nil {
+		unmap := m.unmap
+		m.unmap = nil
+		return unmap()
+	}
+	return nil
+}
+
+// Header returns the header of the message, as it is in the mailbox
+func (mm *MappedMessage) Header() []byte {
+	if blankLine(mm.Raw) {
+		return mm.Raw[:0]
+	}
+	if i, _ := headerEnd(mm.Raw); i != -1 {
+		return mm.Raw[:i+1]
+	}
+	return mm.Raw
+}
+
+// Escaped returns true if the message has lines that Content unescapes
+func (mm *MappedMessage) Escaped() bool {
+	if mm.variant != MboxRD {
+		return false
+	}
+	for pos := 0; pos < len(mm.Raw); {
+		if escapedFrom(mm.Raw[pos:]) > 0 {
+			return true
+		}
+		i := bytes.Index(mm.Raw[pos:], []byte{newLine, escape})
+		if i == -1 {
+			return false
+		}
+		pos += i + 1
+	}
+	return false
+}
+
+// Content returns the message unescaped, which is Raw unless the message is Escaped. Then it's a copy of the
+// whole message, since the lines after an escaped line move up by a byte. Use Reader to avoid the copy
+func (mm *MappedMessage) Content() []byte {
+	if !mm.Escaped() {
+		return mm.Raw
+	}
+	out := make([]byte, 0, len(mm.Raw))
+	lines(mm.Raw, func(line []byte, _ int) {
+		if escapedFrom(line) > 0 {
+			line = line[1:]
+		}
+		out = append(out, line...)
+	})
+	return out
+}
+
+// Reader returns the message unescaped, like Content, without copying it. The reader reads the parts of Raw
+// between the '>' that are removed
+func (mm *MappedMessage) Reader() io.Reader {
+	if mm.variant != MboxRD {
+		return bytes.NewReader(mm.Raw)
+	}
+	var parts []io.Reader
+	start := 0
+	lines(mm.Raw, func(line []byte, pos int) {
+		if escapedFrom(line) > 0 {
+			parts = append(parts, bytes.NewReader(mm.Raw[start:pos]))
+			start = pos + 1
+		}
+	})
+	if len(parts) == 0 {
+		return bytes.NewReader(mm.Raw)
+	}
+	return io.MultiReader(append(parts, bytes.NewReader(mm.Raw[start:]))...)
+}
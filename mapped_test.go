@@ -0,0 +1,124 @@
+package mbox
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const mappedTest1 = `From a@example.com Wed Jan 27 02:32:22 2021
+Subject: one
+
+>From escaped
+>>From twice
+
+From b@example.com Wed Jan 27 02:32:23 2021
+
+From c@example.com Wed Jan 27 02:32:24 2021
+Subject: three
+
+three
+
+`
+
+func TestMapped(t *testing.T) {
+	m := NewMapped([]byte(mappedTest1))
+	var msgs []*MappedMessage
+	for msg, err := range m.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 3 {
+		t.Fatal("expecting 3 messages, got", len(msgs))
+	}
+	if !msgs[0].Escaped() || string(msgs[0].Content()) != "Subject: one\n\nFrom escaped\n>From twice\n" {
+		t.Error("unexpected content", string(msgs[0].Content()))
+	}
+	if b, err := io.ReadAll(msgs[0].Reader()); err != nil || string(b) != string(msgs[0].Content()) {
+		t.Errorf("unexpected reader content %q %v", b, err)
+	}
+	if string(msgs[0].Header()) != "Subject: one\n" {
+		t.Error("unexpected header", string(msgs[0].Header()))
+	}
+	if len(msgs[1].Raw) != 0 || msgs[1].Envelope.From != "b@example.com" || msgs[1].Envelope.Offset != 86 {
+		t.Error("unexpected message", msgs[1].Envelope, string(msgs[1].Raw))
+	}
+	content := msgs[2].Content()
+	if msgs[2].Escaped() || string(content) != "Subject: three\n\nthree\n" {
+		t.Error("unexpected content", string(content))
+	}
+	// not a copy
+	if &content[0] != &msgs[2].Raw[0] {
+		t.Error("expecting a slice of the mailbox")
+	}
+	if _, err := m.Next(); err != io.EOF {
+		t.Error("expecting io.EOF, got", err)
+	}
+}
+
+func TestMappedCRLF(t *testing.T) {
+	m := NewMapped([]byte(crlfTest))
+	var msgs []*MappedMessage
+	for msg, err := range m.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 2 {
+		t.Fatal("expecting 2 messages, got", len(msgs))
+	}
+	if string(msgs[0].Header()) != "Subject: one\r\n" {
+		t.Errorf("unexpected header %q", msgs[0].Header())
+	}
+	if string(msgs[0].Content()) != "Subject: one\r\n\r\nFrom the start\r\n\r\nbody\r\n" {
+		t.Errorf("unexpected content %q", msgs[0].Content())
+	}
+	if msgs[1].Envelope.From != "b@example.com" || string(msgs[1].Raw) != "Subject: two\r\n\r\ntwo\r\n" {
+		t.Errorf("unexpected message %v %q", msgs[1].Envelope, msgs[1].Raw)
+	}
+
+	const cl = "From a@example.com Wed Jan 27 02:32:22 2021\r\nContent-Length: 29\r\n\r\nbody\r\n\r\nFrom not a boundary\r\n\r\n" +
+		"From b@example.com Wed Jan 27 02:32:23 2021\r\nContent-Length: 5\r\n\r\ntwo\r\n\r\n"
+	m = NewMapped([]byte(cl), WithVariant(MboxCL2))
+	msgs = msgs[:0]
+	for msg, err := range m.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) != 2 || !strings.HasSuffix(string(msgs[0].Raw), "From not a boundary\r\n") {
+		t.Errorf("unexpected messages %d %q", len(msgs), msgs[0].Raw)
+	}
+}
+
+func TestMappedContentLength(t *testing.T) {
+	const in = "From a@example.com Wed Jan 27 02:32:22 2021\nContent-Length: 26\n\nbody\n\nFrom not a boundary\n\n" +
+		"From b@example.com Wed Jan 27 02:32:23 2021\nContent-Length: 99\n\nwrong length\n\n"
+	m := NewMapped([]byte(in), WithVariant(MboxCL2))
+	msg, err := m.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Content()) != "Content-Length: 26\n\nbody\n\nFrom not a boundary\n" {
+		t.Error("unexpected content", string(msg.Content()))
+	}
+	if msg, err = m.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Content()) != "Content-Length: 99\n\nwrong length\n" {
+		t.Error("unexpected content", string(msg.Content()))
+	}
+}
+
+func TestMappedBad(t *testing.T) {
+	if _, err := NewMapped([]byte("garbage\n")).Next(); err != InvalidFormat {
+		t.Error("expecting InvalidFormat, got", err)
+	}
+	if _, err := NewMapped([]byte("From a@example.com garbage\n\n")).Next(); err != InvalidHeader {
+		t.Error("expecting InvalidHeader, got", err)
+	}
+}
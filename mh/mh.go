@@ -0,0 +1,283 @@
+// Package mh moves messages between mbox streams and MH folders.
+// A folder is a directory of messages in files named by their number. Sequences, such as the unseen and
+// flagged messages, are kept in the .mh_sequences file of the folder
+package mh
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flashmob/mbox"
+)
+
+const (
+	// sequencesFile is the name of the file with the public sequences of a folder
+	sequencesFile = ".mh_sequences"
+	// Unseen is the sequence of messages that were not seen
+	Unseen = "unseen"
+	// Flagged is the sequence of flagged messages
+	Flagged = "flagged"
+)
+
+// Sequences map the name of each sequence to its message numbers, in order
+type Sequences map[string][]int
+
+// ReadSequences reads the .mh_sequences file of the folder at dir. No sequences are returned if there's no file
+func ReadSequences(dir string) (Sequences, error) {
+	s := make(Sequences)
+	data, err := os.ReadFile(filepath.Join(dir, sequencesFile))
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var name string
+	for _, line := range strings.Split(string(data), "\n") {
+		// a line that starts with a space continues the previous sequence
+		value := line
+		if line == "" {
+			continue
+		} else if line[0] != ' ' && line[0] != '\t' {
+			i := strings.IndexByte(line, ':')
+			if i == -1 {
+				return nil, mbox.InvalidFormat
+			}
+			name, value = line[:i], line[i+1:]
+		}
+		for _, f := range strings.Fields(value) {
+			first, last, found := strings.Cut(f, "-")
+			from, err := strconv.Atoi(first)
+			to := from
+			if err == nil && found {
+				to, err = strconv.Atoi(last)
+			}
+			if err != nil || from < 1 || to < from {
+				return nil, mbox.InvalidFormat
+			}
+			for n := from; n <= to; n++ {
+				s[name] = append(s[name], n)
+			}
+		}
+	}
+	for name, nums := range s {
+		sort.Ints(nums)
+		s[name] = slices.Compact(nums)
+	}
+	return s, nil
+}
+
+// Contains returns true if the message number n is in the sequence
+func (s Sequences) Contains(name string, n int) bool {
+	nums := s[name]
+	i := sort.SearchInts(nums, n)
+	return i < len(nums) && nums[i] == n
+}
+
+// Add adds the message number n to the sequence
+func (s Sequences) Add(name string, n int) {
+	nums := s[name]
+	i := sort.SearchInts(nums, n)
+	if i < len(nums) && nums[i] == n {
+		return
+	}
+	nums = append(nums, 0)
+	copy(nums[i+1:], nums[i:])
+	nums[i] = n
+	s[name] = nums
+}
+
+// String returns the sequences as they are written to .mh_sequences, eg. "unseen: 1-3 5"
+func (s Sequences) String() string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		if len(s[name]) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(name)
+		sb.WriteString(":")
+		nums := s[name]
+		for i := 0; i < len(nums); {
+			j := i
+			for j+1 < len(nums) && nums[j+1] == nums[j]+1 {
+				j++
+			}
+			sb.WriteString(" ")
+			sb.WriteString(strconv.Itoa(nums[i]))
+			if j > i {
+				sb.WriteString("-")
+				sb.WriteString(strconv.Itoa(nums[j]))
+			}
+			i = j + 1
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// WriteSequences replaces the .mh_sequences file of the folder at dir
+func WriteSequences(dir string, s Sequences) error {
+	tmp := filepath.Join(dir, sequencesFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(s.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, sequencesFile))
+}
+
+// Numbers returns the message numbers of the folder at dir, in order
+func Numbers(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var nums []int
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if n, err := strconv.Atoi(e.Name()); err == nil && n > 0 && strconv.Itoa(n) == e.Name() {
+			nums = append(nums, n)
+		}
+	}
+	sort.Ints(nums)
+	return nums, nil
+}
+
+// Import adds each message read from the mbox stream r to the folder at dir, which is created if needed.
+// Messages are numbered after the last message of the folder. Messages without the Seen flag in their Status
+// field are added to the unseen sequence, and messages with the Flagged flag in X-Status to the flagged sequence.
+// The modification times are taken from the "From " lines. The number of messages imported is returned
+func Import(dir string, r io.Reader, opts ...mbox.Option) (n int, err error) {
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return 0, err
+	}
+	nums, err := Numbers(dir)
+	if err != nil {
+		return 0, err
+	}
+	seqs, err := ReadSequences(dir)
+	if err != nil {
+		return 0, err
+	}
+	next := 1
+	if len(nums) > 0 {
+		next = nums[len(nums)-1] + 1
+	}
+	defer func() {
+		if n > 0 {
+			if serr := WriteSequences(dir, seqs); err == nil {
+				err = serr
+			}
+		}
+	}()
+	s := mbox.NewScanner(r, opts...)
+	for s.Next() {
+		m := s.Message()
+		br := bufio.NewReader(m.Body)
+		header, err := mbox.ReadHeader(br)
+		if err != nil {
+			return n, err
+		}
+		path := filepath.Join(dir, strconv.Itoa(next))
+		if err = write(path, io.MultiReader(bytes.NewReader(header), br)); err != nil {
+			return n, err
+		}
+		if date := m.Envelope.Date; !date.IsZero() {
+			if err = os.Chtimes(path, date, date); err != nil {
+				return n, err
+			}
+		}
+		f := mbox.HeaderFlags(header)
+		if f&mbox.Seen == 0 {
+			seqs.Add(Unseen, next)
+		}
+		if f&mbox.Flagged != 0 {
+			seqs.Add(Flagged, next)
+		}
+		next++
+		n++
+	}
+	return n, s.Err()
+}
+
+// write creates the file at path, with the contents of r
+func write(path string, r io.Reader) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, r); err != nil {
+		_ = file.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// Export writes each message of the folder at dir to w, in order of their numbers.
+// The "From " lines are built from the Return-Path or Delivered-To fields and the modification times.
+// The Seen and Flagged flags of the Status and X-Status fields are set from the unseen and flagged sequences.
+// mboxrd is written, unless another mbox variant is given in opts. The number of messages exported is returned
+func Export(w io.Writer, dir string, opts ...mbox.Option) (int, error) {
+	nums, err := Numbers(dir)
+	if err != nil {
+		return 0, err
+	}
+	seqs, err := ReadSequences(dir)
+	if err != nil {
+		return 0, err
+	}
+	enc := mbox.NewMailboxWriter(w, opts...)
+	for i, num := range nums {
+		if err = export(enc, filepath.Join(dir, strconv.Itoa(num)), func(f mbox.Flags) mbox.Flags {
+			f &^= mbox.Seen | mbox.Flagged
+			if !seqs.Contains(Unseen, num) {
+				f |= mbox.Seen
+			}
+			if seqs.Contains(Flagged, num) {
+				f |= mbox.Flagged
+			}
+			return f
+		}); err != nil {
+			return i, err
+		}
+	}
+	return len(nums), nil
+}
+
+// export writes the message at path to enc, with its flags changed by flags
+func export(enc *mbox.MailboxWriter, path string, flags func(f mbox.Flags) mbox.Flags) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	br := bufio.NewReader(file)
+	header, err := mbox.ReadHeader(br)
+	if err != nil {
+		return err
+	}
+	e := mbox.Envelope{From: mbox.HeaderEnvelope(header).From, Date: fi.ModTime()}
+	if err = enc.BeginMessage(e); err != nil {
+		return err
+	}
+	header = mbox.SetHeaderFlags(header, flags(mbox.HeaderFlags(header)))
+	if _, err = io.Copy(enc, io.MultiReader(bytes.NewReader(header), br)); err != nil {
+		return err
+	}
+	return enc.EndMessage()
+}
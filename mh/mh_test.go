@@ -0,0 +1,97 @@
+package mh
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/flashmob/mbox"
+)
+
+const test1 = `From test@example.com Wed Jan 27 02:32:22 2021
+Return-Path: <test@example.com>
+Subject: one
+Status: RO
+X-Status: F
+
+>From the start
+
+From test2@example.com Wed Jan 27 02:32:23 2021
+Return-Path: <test2@example.com>
+Subject: two
+
+unseen message
+
+`
+
+func TestSequences(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, sequencesFile), []byte("unseen: 3-5 1\n 7\ncur: 7\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := ReadSequences(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Contains(Unseen, 4) || !s.Contains(Unseen, 7) || s.Contains(Unseen, 2) {
+		t.Error("unexpected unseen sequence", s[Unseen])
+	}
+	s.Add(Flagged, 2)
+	if s.String() != "cur: 7\nflagged: 2\nunseen: 1 3-5 7\n" {
+		t.Error("unexpected sequences", s.String())
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	dir := t.TempDir()
+	// an existing message
+	if err := os.WriteFile(filepath.Join(dir, "1"), []byte("Subject: zero\n\nold\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	n, err := Import(dir, strings.NewReader(test1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Error("expecting 2 messages, got", n)
+	}
+	nums, _ := Numbers(dir)
+	if len(nums) != 3 || nums[2] != 3 {
+		t.Error("unexpected numbers", nums)
+	}
+	s, _ := ReadSequences(dir)
+	if s.String() != "flagged: 2\nunseen: 3\n" {
+		t.Error("unexpected sequences", s.String())
+	}
+
+	// leave out the existing message
+	if err = os.Remove(filepath.Join(dir, "1")); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if n, err = Export(&b, dir); err != nil {
+		t.Error(err)
+	}
+	if n != 2 {
+		t.Error("expecting 2 messages, got", n)
+	}
+	if b.String() != test1 {
+		t.Error("unexpected result", b.String())
+	}
+
+	// the sequences change the flags
+	if err = WriteSequences(dir, Sequences{Unseen: {2}}); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if _, err = Export(&b, dir, mbox.WithVariant(mbox.MboxO)); err != nil {
+		t.Error(err)
+	}
+	expected := strings.Replace(test1, "Status: RO\nX-Status: F\n", "Status: O\n", 1)
+	expected = strings.Replace(expected, "Subject: two\n", "Subject: two\nStatus: R\n", 1)
+	if b.String() != expected {
+		t.Error("unexpected result", b.String())
+	}
+}
@@ -0,0 +1,38 @@
+package mbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// OpenMapped maps the mailbox at path into memory, read only, and returns a Mapped to read it.
+// Close must be called to release the mapping
+func OpenMapped(path string, opts ...Option) (*Mapped, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// the mapping stays valid after the file is closed
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size == 0 {
+		// an empty mapping is not allowed
+		return NewMapped(nil, opts...), nil
+	}
+	if int64(int(size)) != size {
+		return nil, syscall.EFBIG
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	m := NewMapped(data, opts...)
+	m.unmap = func() error {
+		return syscall.Munmap(data)
+	}
+	return m, nil
+}
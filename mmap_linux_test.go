@@ -0,0 +1,42 @@
+package mbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenMapped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	if err := os.WriteFile(path, []byte(mappedTest1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := OpenMapped(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, err := range m.All() {
+		if err != nil {
+			t.Error(err)
+		}
+		n++
+	}
+	if n != 3 {
+		t.Error("expecting 3 messages, got", n)
+	}
+	if err = m.Close(); err != nil {
+		t.Error(err)
+	}
+	// an empty file
+	if err = os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if m, err = OpenMapped(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Next(); err == nil {
+		t.Error("expecting no messages")
+	}
+	_ = m.Close()
+}
@@ -0,0 +1,197 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+)
+
+// mmdfDelimiter is the line that starts and ends each MMDF message, mmdfDelimiterCRLF is the same line
+// ending with "\r\n"
+const (
+	mmdfDelimiter     = "\x01\x01\x01\x01\n"
+	mmdfDelimiterCRLF = "\x01\x01\x01\x01\r\n"
+)
+
+// mmdfDecoder reads MMDF mailboxes, where each message is enclosed by two lines of four Ctrl-A characters.
+// Nothing is escaped. The lines may end with "\r\n"
+type mmdfDecoder struct {
+	r *bufio.Reader
+	// pending is what's left of a line that did not fit in p
+	pending []byte
+	// inMessage is true between the delimiters
+	inMessage bool
+	// lineStart is true if the next byte is at the start of a line
+	lineStart bool
+	// pos is the offset of the next byte from r
+	pos int64
+	// start is the offset of the delimiter that started the current message
+	start int64
+	// lines is the number of lines read, and messages the number of messages started
+	lines    int64
+	messages int
+
+	config
+}
+
+// NewMMDFReader returns an io.Reader, ready to decode MMDF streams.
+// Like NewReader, io.EOF is returned at the end of each message, so the reader can be used again to
+// read the next message
+func NewMMDFReader(r io.Reader, opts ...Option) *mmdfDecoder {
+	d := new(mmdfDecoder)
+	d.r = bufio.NewReaderSize(r, defaultBufferSize)
+	d.config = newConfig(opts)
+	return d
+}
+
+// Read implements io.Reader
+func (r *mmdfDecoder) Read(p []byte) (int, error) {
+	if !r.inMessage {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for n < len(p) {
+		if len(r.pending) > 0 {
+			c := copy(p[n:], r.pending)
+			r.pending = r.pending[c:]
+			n += c
+			continue
+		}
+		line, err := r.r.ReadSlice(newLine)
+		if err == io.EOF {
+			if len(line) == 0 {
+				// the closing delimiter is missing
+				return n, r.parseError()
+			}
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return n, err
+		}
+		r.pos += int64(len(line))
+		if line[len(line)-1] == newLine {
+			r.lines++
+		}
+		if r.lineStart && isMMDFDelimiter(line) {
+			r.inMessage = false
+			return n, io.EOF
+		}
+		r.lineStart = line[len(line)-1] == newLine
+		r.pending = line
+	}
+	return n, nil
+}
+
+// next reads the delimiter that starts the next message. io.EOF is returned if there are no more messages
+func (r *mmdfDecoder) next() error {
+	if r.inMessage {
+		return r.parseError()
+	}
+	line, err := r.r.ReadSlice(newLine)
+	if err == io.EOF && len(line) == 0 {
+		return io.EOF
+	}
+	r.messages++
+	if !isMMDFDelimiter(line) {
+		return r.parseError()
+	}
+	r.start = r.pos
+	r.pos += int64(len(line))
+	r.lines++
+	r.inMessage = true
+	r.lineStart = true
+	return nil
+}
+
+// isMMDFDelimiter returns true if line is the delimiter, with either line ending
+func isMMDFDelimiter(line []byte) bool {
+	return string(line) == mmdfDelimiter || string(line) == mmdfDelimiterCRLF
+}
+
+// parseError returns InvalidFormat as a ParseError, at the current position
+func (r *mmdfDecoder) parseError() error {
+	return &ParseError{Err: InvalidFormat, Offset: r.pos, Line: r.lines + 1, Message: max(r.messages-1, 0)}
+}
+
+// Envelope returns the envelope of the current message, MMDF messages only have an offset
+func (r *mmdfDecoder) Envelope() (Envelope, error) {
+	return Envelope{Offset: r.start}, nil
+}
+
+// Close resets all state
+func (r *mmdfDecoder) Close() error {
+	r.pending = nil
+	r.inMessage = false
+	return nil
+}
+
+// mmdfEncoder writes MMDF mailboxes
+type mmdfEncoder struct {
+	c   counter
+	env Envelope
+	// last is the last byte written
+	last byte
+
+	config
+}
+
+// NewMMDFWriter returns an io.Writer, ready to encode messages to MMDF streams.
+// A message must not contain a line of four Ctrl-A characters, since MMDF has no escaping
+func NewMMDFWriter(w io.Writer, opts ...Option) *mmdfEncoder {
+	e := new(mmdfEncoder)
+	e.c.w = w
+	e.config = newConfig(opts)
+	return e
+}
+
+// Open begins a new message. from and t are not part of MMDF, but are kept for Envelope
+func (w *mmdfEncoder) Open(from string, t time.Time) error {
+	return w.OpenEnvelope(Envelope{From: from, Date: t})
+}
+
+// OpenEnvelope begins a new message. Only the offset of the envelope is used by MMDF
+func (w *mmdfEncoder) OpenEnvelope(e Envelope) error {
+	w.env = e
+	w.env.Offset = w.c.n
+	w.last = newLine
+	_, err := io.Copy(&w.c, bytes.NewReader([]byte(mmdfDelimiter)))
+	return err
+}
+
+// Envelope returns the envelope of the current message
+func (w *mmdfEncoder) Envelope() Envelope {
+	return w.env
+}
+
+// Write implements io.Writer, p is written as-is
+func (w *mmdfEncoder) Write(p []byte) (int, error) {
+	n64, err := io.Copy(&w.c, bytes.NewReader(p))
+	if n64 > 0 {
+		w.last = p[n64-1]
+	}
+	return int(n64), err
+}
+
+// offset returns the number of bytes written to the underlying writer
+func (w *mmdfEncoder) offset() int64 {
+	return w.c.n
+}
+
+// rollback abandons the current message, the underlying writer was truncated to n bytes
+func (w *mmdfEncoder) rollback(n int64) {
+	w.c.n = n
+}
+
+// Close ends the message, and closes the underlying writer if it's an io.Closer
+func (w *mmdfEncoder) Close() error {
+	end := mmdfDelimiter
+	if w.last != newLine {
+		end = string(newLine) + end
+	}
+	_, err := io.Copy(&w.c, bytes.NewReader([]byte(end)))
+	if closer, ok := w.c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return err
+}
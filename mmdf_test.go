@@ -0,0 +1,134 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mmdfTest1 = "\x01\x01\x01\x01\nSubject: one\n\nFrom the start\n\x01\x01\x01\x01\n" +
+	"\x01\x01\x01\x01\nSubject: two\n\nline \x01\x01\x01\x01\n\x01\x01\x01\x01\n" +
+	"\x01\x01\x01\x01\n\x01\x01\x01\x01\n"
+
+func TestMMDFRead(t *testing.T) {
+	buf := make([]byte, 8)
+	var b bytes.Buffer
+	r := NewMMDFReader(bytes.NewReader([]byte(mmdfTest1)))
+	_, err := io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Subject: one\n\nFrom the start\n" {
+		t.Error("unexpected message", b.String())
+	}
+	b.Reset()
+	// the reader can be used again to read the next message
+	_, err = io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Subject: two\n\nline \x01\x01\x01\x01\n" {
+		t.Error("unexpected message", b.String())
+	}
+	if e, _ := r.Envelope(); e.Offset != 39 {
+		t.Error("expecting offset 39, got", e.Offset)
+	}
+	if err = r.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMMDFReadCRLF(t *testing.T) {
+	in := strings.ReplaceAll(mmdfTest1, "\n", "\r\n")
+	s := NewScanner(strings.NewReader(in), WithVariant(MMDF))
+	var bodies []string
+	for s.Next() {
+		body, err := io.ReadAll(s.Message().Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, string(body))
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	if !slices.Equal(bodies, []string{"Subject: one\r\n\r\nFrom the start\r\n", "Subject: two\r\n\r\nline \x01\x01\x01\x01\r\n", ""}) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+}
+
+func TestMMDFReadBad(t *testing.T) {
+	for _, test := range []struct {
+		in           string
+		offset, line int64
+	}{
+		{"garbage\n", 0, 1},
+		{"\x01\x01\x01\x01\nno end\n", 12, 3},
+		{"\x01\x01\x01\x01\nno end", 11, 2},
+	} {
+		s := NewScanner(bytes.NewReader([]byte(test.in)), WithVariant(MMDF))
+		for s.Next() {
+		}
+		var pe *ParseError
+		if !errors.As(s.Err(), &pe) || !errors.Is(pe, InvalidFormat) {
+			t.Errorf("%q expecting InvalidFormat, got %v", test.in, s.Err())
+		} else if pe.Offset != test.offset || pe.Line != test.line || pe.Message != 0 {
+			t.Errorf("%q unexpected error %v", test.in, pe)
+		}
+	}
+}
+
+func TestMMDFScanner(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte(mmdfTest1)), WithVariant(MMDF))
+	var bodies []string
+	var offsets []int64
+	for m, err := range s.All() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(m.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, string(body))
+		offsets = append(offsets, m.Envelope.Offset)
+	}
+	if len(bodies) != 3 {
+		t.Fatal("expecting 3 messages, got", len(bodies))
+	}
+	if bodies[2] != "" {
+		t.Error("expecting an empty message")
+	}
+	if offsets[0] != 0 || offsets[1] != 39 || offsets[2] != 73 {
+		t.Error("unexpected offsets", offsets)
+	}
+}
+
+func TestMMDFWrite(t *testing.T) {
+	var b bytes.Buffer
+	w := NewMMDFWriter(struct{ io.Writer }{&b})
+	for _, msg := range []string{"Subject: one\n\nFrom the start\n", "Subject: two\n\nno newline", ""} {
+		if err := w.Open("test@example.com", time.Now()); err != nil {
+			t.Error(err)
+		}
+		if _, err := io.Copy(w, strings.NewReader(msg)); err != nil {
+			t.Error(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+	expected := "\x01\x01\x01\x01\nSubject: one\n\nFrom the start\n\x01\x01\x01\x01\n" +
+		"\x01\x01\x01\x01\nSubject: two\n\nno newline\n\x01\x01\x01\x01\n" +
+		"\x01\x01\x01\x01\n\x01\x01\x01\x01\n"
+	if b.String() != expected {
+		t.Errorf("unexpected result %q", b.String())
+	}
+	if w.Envelope().Offset != 74 {
+		t.Error("expecting offset 74, got", w.Envelope().Offset)
+	}
+}
@@ -0,0 +1,94 @@
+package mbox
+
+import "time"
+
+// Option configures a reader or writer, see NewReader, NewWriter and NewScanner
+type Option func(*config)
+
+// config holds the settings given by the options
+type config struct {
+	// variant is the format variant to read or write
+	variant Variant
+	// layouts are the date layouts to try when parsing the "From " line
+	layouts []string
+	// recovery makes the decoder continue after the problems it can recover from
+	recovery bool
+	// report is called with each problem that was recovered from
+	report func(err *ParseError)
+	// strict only accepts a "From " line after a blank line as a boundary if it's a valid envelope
+	strict bool
+	// checkHeaders also requires the lines after the "From " line to be header fields, in strict mode
+	checkHeaders bool
+	// lineEnding is the line ending policy
+	lineEnding LineEnding
+	// locks are the locks taken by AppendFile, and lockTimeout how long it waits for them
+	locks       LockMethod
+	lockTimeout time.Duration
+	// sync makes MailboxWriter sync the file after each message
+	sync bool
+}
+
+// newConfig returns the config after applying opts
+func newConfig(opts []Option) config {
+	c := config{
+		layouts:     DateLayouts,
+		locks:       DefaultLocks,
+		lockTimeout: defaultLockTimeout,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// WithDateLayouts sets the layouts to try, in order, when parsing the date of a "From " line.
+// The default is DateLayouts
+func WithDateLayouts(layouts ...string) Option {
+	return func(c *config) {
+		c.layouts = layouts
+	}
+}
+
+// WithVariant sets the mbox variant to read or write. The default is MboxRD
+func WithVariant(v Variant) Option {
+	return func(c *config) {
+		c.variant = v
+	}
+}
+
+// WithRecovery makes the decoder lenient with damaged mailboxes. Garbage before the first "From " line is
+// skipped, a missing blank line at the end is accepted, an empty stream has no messages, and a message
+// with an invalid "From " line is still returned by the Scanner, with what could be parsed of its envelope.
+// Each problem is passed to the function given by WithReport, instead of stopping the decoder
+func WithRecovery() Option {
+	return func(c *config) {
+		c.recovery = true
+	}
+}
+
+// WithReport sets a function that's called with each problem found in the stream that didn't stop the
+// decoder, see WithRecovery
+func WithReport(f func(err *ParseError)) Option {
+	return func(c *config) {
+		c.report = f
+	}
+}
+
+// WithStrict makes the decoder only accept a "From " line after a blank line as the start of a message if
+// the rest of the line is a valid envelope, with an address and a date. If checkHeaders is true, the lines
+// after it must also look like the header fields of a message. Any other "From " line is kept in the body,
+// and reported as UnescapedFrom to the function given by WithReport.
+// This avoids splitting messages that were written without escaping, at the cost of looking ahead
+func WithStrict(checkHeaders bool) Option {
+	return func(c *config) {
+		c.strict = true
+		c.checkHeaders = checkHeaders
+	}
+}
+
+// reportError passes err to the report function, if there's one
+func (c *config) reportError(err *ParseError) {
+	if c.report != nil {
+		c.report(err)
+	}
+}
@@ -0,0 +1,192 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// defaultChunkSize is the default size of the chunks decoded by each worker of a ParallelScanner
+const defaultChunkSize = 64 * 1024 * 1024
+
+// ParallelScanner decodes the messages of a mailbox concurrently. The mailbox is split into chunks at the
+// boundaries found by ReaderAt.Sync, and the chunks are decoded by a pool of workers.
+// Only the variants that escape every "From " line at the start of a line can be split, InvalidFormat is
+// returned by Scan for mboxcl, mboxcl2, MMDF and Babyl. A function given by WithReport is called concurrently
+// by the workers
+type ParallelScanner struct {
+	// Workers is the number of workers, runtime.GOMAXPROCS(0) if it's 0
+	Workers int
+	// ChunkSize is the approximate size of each chunk, 64MiB if it's 0
+	ChunkSize int64
+	// Ordered delivers the messages in the order of the mailbox
+	Ordered bool
+
+	r *ReaderAt
+}
+
+// NewParallelScanner returns a ParallelScanner for the mailbox r of size bytes
+func NewParallelScanner(r io.ReaderAt, size int64, opts ...Option) *ParallelScanner {
+	return &ParallelScanner{r: NewReaderAt(r, size, opts...)}
+}
+
+// chunk is a part of the mailbox that starts and ends at message boundaries
+type chunk struct {
+	start, end int64
+	// messages are the decoded messages, when Ordered
+	messages []*Message
+	err      error
+	done     chan struct{}
+}
+
+// chunks splits the mailbox
+func (p *ParallelScanner) chunks() ([]*chunk, error) {
+	size := p.ChunkSize
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	var chunks []*chunk
+	start := int64(0)
+	for start < p.r.size {
+		end, err := p.r.Sync(start + size)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		chunks = append(chunks, &chunk{start: start, end: end, done: make(chan struct{})})
+		start = end
+	}
+	return chunks, nil
+}
+
+// Scan calls f with each message, and returns the first error returned by f or by the decoder.
+// If Ordered is false, f is called concurrently by the workers, and the body is read from the mailbox.
+// If Ordered is true, the workers read the bodies into memory, and f is called by the calling goroutine
+// in the order of the mailbox. The body is only valid until f returns
+func (p *ParallelScanner) Scan(f func(m *Message) error) error {
+	if v := p.r.variant; !hasEnvelope(v) || v == MboxCL || v == MboxCL2 {
+		// a "From " line in the body of a mboxcl message could be taken as a boundary
+		return InvalidFormat
+	}
+	chunks, err := p.chunks()
+	if err != nil {
+		return err
+	}
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var (
+		jobs = make(chan *chunk)
+		// stop is closed to stop the workers after an error
+		stop     = make(chan struct{})
+		stopOnce sync.Once
+		wg       sync.WaitGroup
+	)
+	halt := func() {
+		stopOnce.Do(func() { close(stop) })
+	}
+	// window limits the ordered chunks that are decoded ahead of f
+	window := make(chan struct{}, 2*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range jobs {
+				c.err = p.decode(c, f, stop)
+				if c.err != nil {
+					halt()
+				}
+				close(c.done)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, c := range chunks {
+			if p.Ordered {
+				select {
+				case window <- struct{}{}:
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case jobs <- c:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	if p.Ordered {
+		err = p.deliver(chunks, f, stop, window)
+		if err != nil {
+			halt()
+		}
+	}
+	wg.Wait()
+	halt()
+	if err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		select {
+		case <-c.done:
+			if c.err != nil {
+				return c.err
+			}
+		default:
+			// not decoded, because of an error in another chunk
+		}
+	}
+	return nil
+}
+
+// decode decodes the messages of c. If Ordered, the messages are kept for deliver, otherwise f is called
+func (p *ParallelScanner) decode(c *chunk, f func(m *Message) error, stop chan struct{}) error {
+	s := p.r.scanner(c.start, c.end)
+	for s.Next() {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		m := s.Message()
+		m.Envelope.Offset += c.start
+		if !p.Ordered {
+			if err := f(m); err != nil {
+				return err
+			}
+			continue
+		}
+		body, err := io.ReadAll(m.Body)
+		if err != nil {
+			return err
+		}
+		c.messages = append(c.messages, &Message{Envelope: m.Envelope, Body: bytes.NewReader(body)})
+	}
+	return s.Err()
+}
+
+// deliver calls f with the messages of each chunk, in order
+func (p *ParallelScanner) deliver(chunks []*chunk, f func(m *Message) error, stop, window chan struct{}) error {
+	for _, c := range chunks {
+		select {
+		case <-c.done:
+		case <-stop:
+			// a worker failed, its error is returned by Scan
+			return nil
+		}
+		if c.err != nil {
+			return c.err
+		}
+		for i, m := range c.messages {
+			c.messages[i] = nil
+			if err := f(m); err != nil {
+				return err
+			}
+		}
+		<-window
+	}
+	return nil
+}
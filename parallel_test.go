@@ -0,0 +1,125 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// parallelTest returns a mailbox of n messages, with escaped "From " lines
+func parallelTest(n int) []byte {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	date := time.Date(2021, 1, 27, 2, 32, 22, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		_ = w.Open(fmt.Sprintf("test%d@example.com", i), date)
+		_, _ = fmt.Fprintf(w, "Subject: %d\n\nFrom the start\n\nFrom %d\n>From quoted\n", i, i)
+		_ = w.Close()
+	}
+	return b.Bytes()
+}
+
+func TestParallelScanOrdered(t *testing.T) {
+	data := parallelTest(200)
+	p := NewParallelScanner(bytes.NewReader(data), int64(len(data)))
+	p.Workers = 4
+	p.ChunkSize = 500
+	p.Ordered = true
+	i := 0
+	err := p.Scan(func(m *Message) error {
+		if m.Envelope.From != fmt.Sprintf("test%d@example.com", i) {
+			t.Error("unexpected message", m.Envelope.From, "expecting", i)
+		}
+		body, err := io.ReadAll(m.Body)
+		if err != nil {
+			return err
+		}
+		if string(body) != fmt.Sprintf("Subject: %d\n\nFrom the start\n\nFrom %d\n>From quoted\n", i, i) {
+			t.Error("unexpected body", string(body))
+		}
+		if !bytes.HasPrefix(data[m.Envelope.Offset:], []byte(m.Envelope.Raw)) {
+			t.Error("unexpected offset", m.Envelope.Offset)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if i != 200 {
+		t.Error("expecting 200 messages, got", i)
+	}
+}
+
+func TestParallelScanUnordered(t *testing.T) {
+	data := parallelTest(200)
+	p := NewParallelScanner(bytes.NewReader(data), int64(len(data)))
+	p.ChunkSize = 1000
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]bool)
+	)
+	err := p.Scan(func(m *Message) error {
+		if _, err := io.Copy(io.Discard, m.Body); err != nil {
+			return err
+		}
+		mu.Lock()
+		seen[m.Envelope.From] = true
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(seen) != 200 {
+		t.Error("expecting 200 messages, got", len(seen))
+	}
+}
+
+func TestParallelScanError(t *testing.T) {
+	data := parallelTest(100)
+	stop := errors.New("stop")
+	for _, ordered := range []bool{true, false} {
+		p := NewParallelScanner(bytes.NewReader(data), int64(len(data)))
+		p.ChunkSize = 500
+		p.Ordered = ordered
+		if err := p.Scan(func(m *Message) error { return stop }); err != stop {
+			t.Error("expecting the error from f, got", err)
+		}
+	}
+}
+
+// the boundaries of mboxcl can't be found without reading the messages in order
+func TestParallelScanVariants(t *testing.T) {
+	data := parallelTest(10)
+	for _, v := range []Variant{MboxCL, MboxCL2, MMDF, Babyl} {
+		p := NewParallelScanner(bytes.NewReader(data), int64(len(data)), WithVariant(v))
+		if err := p.Scan(func(m *Message) error { return nil }); err != InvalidFormat {
+			t.Error(v, "expecting InvalidFormat, got", err)
+		}
+	}
+}
+
+func TestParallelScanOptions(t *testing.T) {
+	p := NewParallelScanner(strings.NewReader(crlfTest), int64(len(crlfTest)), WithLineEnding(LineEndingLF))
+	p.ChunkSize = 10
+	p.Ordered = true
+	var bodies []string
+	err := p.Scan(func(m *Message) error {
+		body, err := io.ReadAll(m.Body)
+		bodies = append(bodies, string(body))
+		return err
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !slices.Equal(bodies, []string{"Subject: one\n\nFrom the start\n\nbody\n", "Subject: two\n\ntwo\n"}) {
+		t.Errorf("unexpected bodies %q", bodies)
+	}
+}
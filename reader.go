@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -25,6 +26,46 @@ type decoder struct {
 	hPos        int
 
 	header strings.Builder
+
+	// pos is the stream offset of input[0]
+	pos int64
+	// start is the stream offset of the current "From " line
+	start int64
+	// headerOnly makes Read return as soon as the "From " line was read
+	headerOnly bool
+
+	// cl is the Content-Length of the current message, -1 if not known
+	cl int64
+	// hLines counts the header lines of the current message
+	hLines int
+	// line is the start of the current header line
+	line []byte
+
+	// lines counts the lines of the input up to input[counted]
+	lines   int64
+	counted int
+	// messages counts the "From " lines
+	messages int
+	// startLine is the line number of the current "From " line
+	startLine int64
+
+	// skipping is true while garbage before a "From " line is skipped, in recovery mode
+	skipping bool
+	// tail is what was left to output when the stream ended without a blank line, in recovery mode
+	tail []byte
+
+	// crlf is true if the blank line before a possible "From " ended with "\r\n"
+	crlf bool
+	// out is the output of read after the line endings were converted, its error is outErr
+	out    []byte
+	outErr error
+	// outBuf holds out, and conv is the buffer for read
+	outBuf []byte
+	conv   []byte
+	// outCR is true if the output so far ends with "\r"
+	outCR bool
+
+	config
 }
 
 type readState int
@@ -51,45 +92,118 @@ const (
 	readStateEnd
 	// readStateNextRecord entering a new message
 	readStateNextRecord
+	// readStateCLHeader at the start of a header line, looking for Content-Length (mboxcl, mboxcl2)
+	readStateCLHeader
+	// readStateCLHeaderCopy copy the header line until the eol
+	readStateCLHeaderCopy
+	// readStateCLBody copy the body, using the Content-Length
+	readStateCLBody
+	// readStateCLEnd after the body, expecting the blank line
+	readStateCLEnd
+	// readStateSkip skip a line that's not part of a message, in recovery mode
+	readStateSkip
+	// readStateCR a "\r" at the start of a line, it's a blank line if "\n" follows
+	readStateCR
 )
 
 const escape = '>'
 
+// maxLineKeep is the maximum length kept of a header line, when looking for Content-Length
+const maxLineKeep = 128
+
+// maxPeek is the maximum input looked ahead in strict mode, to validate a "From " line
+const maxPeek = 8 * 1024
+
+// defaultBufferSize is the size of the input buffer when the size is not given by the caller
+const defaultBufferSize = 32 * 1024
+
 // InvalidFormat error is returned when the file format is invalid
 var InvalidFormat = errors.New("invalid file format")
 
 // InvalidHeader error is returned when Header() date is invalid
 var InvalidHeader = errors.New("invalid header")
 
+// UnescapedFrom is reported in strict mode for a "From " line after a blank line that was kept in the body
+var UnescapedFrom = errors.New("unescaped From line")
+
 // NewReader returns an io.Reader, ready to decode mbox streams
-func NewReader(r io.Reader) *decoder {
+func NewReader(r io.Reader, opts ...Option) *decoder {
 	d := new(decoder)
 	d.r = r
+	d.config = newConfig(opts)
 	return d
 }
 
 // Read implements io.Reader
 func (r *decoder) Read(p []byte) (int, error) {
+	if r.lineEnding == LineEndingKeep {
+		return r.read(p)
+	}
+	if len(r.out) == 0 {
+		if r.conv == nil {
+			r.conv = make([]byte, len(p))
+		}
+		n, err := r.read(r.conv)
+		if r.lineEnding == LineEndingLF {
+			r.outBuf = toLF(r.outBuf[:0], r.conv[:n], &r.outCR, err != nil)
+		} else {
+			r.outBuf = toCRLF(r.outBuf[:0], r.conv[:n], &r.outCR)
+			if err != nil {
+				r.outCR = false
+			}
+		}
+		if len(r.outBuf) == 0 {
+			return 0, err
+		}
+		r.out, r.outErr = r.outBuf, err
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	if len(r.out) > 0 {
+		return n, nil
+	}
+	err := r.outErr
+	r.outErr = nil
+	return n, err
+}
+
+// read decodes the stream to p, with the line endings as they are
+func (r *decoder) read(p []byte) (int, error) {
 	// n counts how many bytes were placed on p
 	var i, n int
 	if r.input == nil {
 		r.input = make([]byte, len(p))
 	}
+	if len(r.tail) > 0 {
+		n = copy(p, r.tail)
+		r.tail = r.tail[n:]
+		return n, nil
+	}
 	if r.iPos == r.iN { // at the end or no input?
 		// get some input to process
+		r.lineAt(r.iN)
+		r.pos += int64(r.iN)
 		r.iN, r.err = r.r.Read(r.input)
+		r.iPos = 0
+		r.counted = 0
 		if r.err == io.EOF {
-			if r.state < readStateStartLine {
-				r.err = InvalidHeader
+			if r.recovery && r.state != readStateEnd {
+				r.recoverEOF()
+				if len(r.tail) > 0 {
+					n = copy(p, r.tail)
+					r.tail = r.tail[n:]
+					return n, nil
+				}
+			} else if r.state < readStateStartLine {
+				r.err = r.parseError(InvalidHeader)
 			} else if r.state != readStateEnd {
-				r.err = InvalidFormat
+				r.err = r.parseError(InvalidFormat)
 			}
 		}
 		if r.iN == 0 {
 			// nothing to process
 			return i, r.err
 		}
-		r.iPos = 0 // reset
 	}
 	if r.iN == 0 && r.err == io.EOF {
 		// nothing to process
@@ -108,12 +222,27 @@ func (r *decoder) Read(p []byte) (int, error) {
 		case readStateHeaderMagic, readStateHeaderMagicEOF:
 			// match the "From " magic string
 			if r.input[r.iPos] == header[r.matches] {
+				if r.matches == 0 {
+					r.start = r.pos + int64(r.iPos)
+					r.startLine = r.lineAt(r.iPos)
+				}
 				r.iPos++
 				r.matches++
 				if r.matches == len(header) {
+					if r.state == readStateHeaderMagicEOF && r.strict && !r.boundary() {
+						// the line is part of the body, output it after the blank line
+						err := r.parseError(UnescapedFrom)
+						err.Offset, err.Line = r.start, r.startLine
+						r.reportError(err)
+						r.state = readStatePutEol
+						continue
+					}
 					lastState := r.state
 					r.state = readStateHeaderValues
 					r.matches = 0
+					r.messages++
+					r.skipping = false
+					r.crlf = false
 					if lastState == readStateHeaderMagicEOF {
 						// a boundary was detected , we return with an io.EOF
 						// Note that the state is not reset, so the reader can be recycled to continue
@@ -125,17 +254,52 @@ func (r *decoder) Read(p []byte) (int, error) {
 				continue
 			} else {
 				if r.state == readStateHeaderMagicEOF {
+					if r.matches == 0 && r.input[r.iPos] == newLine && !r.crlf {
+						// another blank line, output the previous one and look for "From " after this one
+						p[i] = newLine
+						i++
+						n++
+						r.iPos++
+						r.state = readStateEnd
+						continue
+					}
 					r.state = readStatePutEol
 					continue
 				}
-				return n, InvalidFormat
+				if r.recovery {
+					// skip the line, "From " may start the next one
+					if !r.skipping {
+						r.skipping = true
+						err := r.parseError(InvalidFormat)
+						if r.matches > 0 {
+							err.Offset, err.Line = r.start, r.startLine
+						}
+						r.reportError(err)
+					}
+					r.matches = 0
+					r.state = readStateSkip
+					continue
+				}
+				return n, r.parseError(InvalidFormat)
 			}
 		case readStatePutEol:
 			// an eol was previously matched, it's not eof, so write it out
+			if len(p)-i > 0 && r.crlf {
+				p[i] = carriageReturn
+				i++
+				n++
+				r.crlf = false
+				continue
+			}
 			if len(p)-i > 0 {
 				p[i] = newLine
 				i++
+				n++
 				r.state = readStateOutputFrom
+				if r.matches == 0 {
+					// nothing of "From " matched, the line may still be escaped
+					r.state = readStateStartLine
+				}
 			}
 		case readStateHeaderValues:
 			// scan until eol
@@ -146,6 +310,15 @@ func (r *decoder) Read(p []byte) (int, error) {
 				r.escapeCount = 0
 				r.state = readStateStartLine
 				r.iPos += i + 1
+				if r.variant == MboxCL || r.variant == MboxCL2 {
+					r.cl = -1
+					r.hLines = 0
+					r.state = readStateCLHeader
+				}
+				if r.headerOnly {
+					r.headerOnly = false
+					return n, nil
+				}
 				continue
 			}
 			r.header.Write(r.input[r.iPos : r.iPos+length])
@@ -154,6 +327,11 @@ func (r *decoder) Read(p []byte) (int, error) {
 			// current pos is after a \n
 			// match >+
 			// else go to state readStateOutputFrom
+			if r.variant != MboxRD && r.input[r.iPos] != newLine && r.input[r.iPos] != carriageReturn {
+				// only mboxrd unescapes
+				r.state = readStateCopy
+				continue
+			}
 			if r.input[r.iPos] == escape {
 				r.escapeCount++
 			} else if r.escapeCount > 0 && r.input[r.iPos] == header[0] {
@@ -168,6 +346,11 @@ func (r *decoder) Read(p []byte) (int, error) {
 				r.iPos++
 				r.state = readStateEnd
 				continue
+			} else if r.escapeCount == 0 && r.input[r.iPos] == carriageReturn {
+				// may be a blank line ending with "\r\n"
+				r.iPos++
+				r.state = readStateCR
+				continue
 			} else {
 				// output
 				if r.escapeCount > 0 { // tested by TestRead6
@@ -183,8 +366,7 @@ func (r *decoder) Read(p []byte) (int, error) {
 			// if entire "From " matched, then we can just --escapeCount
 			// goto state readStateOutputFrom
 			if r.matches == len(header) {
-				r.escapeCount-- // strip a single ">". Assuming that r.escapeCount > 9
-				r.iPos++
+				r.escapeCount-- // strip a single ">". Assuming that r.escapeCount > 0
 				r.state = readStateOutputFrom
 				continue
 			} else if r.input[r.iPos] == header[r.matches] {
@@ -219,10 +401,11 @@ func (r *decoder) Read(p []byte) (int, error) {
 					break
 				}
 			}
-			if r.matches == 0 {
+			if r.escapeCount == 0 && r.matches == 0 {
+				// all of the pattern was output, otherwise continue with the next p
 				r.hPos = 0
+				r.state = readStateCopy
 			}
-			r.state = readStateCopy
 		case readStateCopy:
 			// copy state
 			// scan until eol
@@ -242,6 +425,94 @@ func (r *decoder) Read(p []byte) (int, error) {
 			n += copied
 			r.iPos += length
 			i += length
+		case readStateCLHeader:
+			// a header line, or the blank line that ends the headers
+			if r.cl == -1 && r.input[r.iPos] == newLine {
+				// without a Content-Length, only "From " lines can be used, one may follow the blank line
+				r.iPos++
+				r.state = readStateEnd
+				continue
+			}
+			if r.cl == -1 && r.input[r.iPos] == carriageReturn {
+				// the blank line may end with "\r\n"
+				r.iPos++
+				r.state = readStateCR
+				continue
+			}
+			if r.input[r.iPos] != newLine {
+				r.line = r.line[:0]
+				r.hLines++
+				r.state = readStateCLHeaderCopy
+				continue
+			}
+			p[i] = newLine
+			i++
+			n++
+			r.iPos++
+			r.state = readStateCLBody
+		case readStateCLHeaderCopy:
+			// same as readStateCopy, but keep the start of the line
+			remaining := len(p) - i
+			length := r.iN - r.iPos
+			if length > remaining {
+				length = remaining
+			}
+			found := false
+			if i := bytes.Index(r.input[r.iPos:r.iPos+length], eol); i != -1 {
+				length = i + 1
+				found = true
+			}
+			if keep := maxLineKeep - len(r.line); keep > 0 {
+				if keep > length {
+					keep = length
+				}
+				r.line = append(r.line, r.input[r.iPos:r.iPos+keep]...)
+			}
+			copied := copy(p[i:], r.input[r.iPos:r.iPos+length])
+			n += copied
+			r.iPos += length
+			i += length
+			if found && len(r.line) == 2 && r.line[0] == carriageReturn {
+				// the blank line that ends the headers, it ends with "\r\n", and the Content-Length is known
+				r.hLines--
+				r.state = readStateCLBody
+			} else if found {
+				if cl, ok := contentLength(r.line); ok && len(r.line) < maxLineKeep {
+					r.cl = cl
+				}
+				r.state = readStateCLHeader
+			}
+		case readStateCLBody:
+			// copy the body without looking at it
+			length := int64(r.iN - r.iPos)
+			if remaining := int64(len(p) - i); length > remaining {
+				length = remaining
+			}
+			if length > r.cl {
+				length = r.cl
+			}
+			copied := copy(p[i:], r.input[r.iPos:r.iPos+int(length)])
+			n += copied
+			r.iPos += copied
+			i += copied
+			r.cl -= int64(copied)
+			if r.cl == 0 {
+				r.state = readStateCLEnd
+			}
+		case readStateCLEnd:
+			// the blank line should follow the body
+			if r.input[r.iPos] == newLine {
+				r.iPos++
+				r.state = readStateEnd
+				continue
+			}
+			if r.input[r.iPos] == carriageReturn {
+				r.iPos++
+				r.state = readStateCR
+				continue
+			}
+			// the Content-Length was wrong, continue with "From " lines
+			r.state = readStateCopy
 		case readStateEnd:
 			// eof state
 			// don't do anything here, it can exit if io.EOF is read
@@ -250,35 +521,264 @@ func (r *decoder) Read(p []byte) (int, error) {
 				// but it might be the end if we read in the magic "From "
 				r.state = readStateHeaderMagicEOF
 			}
+		case readStateSkip:
+			// skip until the eol
+			if i := bytes.IndexByte(r.input[r.iPos:r.iN], newLine); i != -1 {
+				r.iPos += i + 1
+				r.state = readStateHeaderMagic
+				continue
+			}
+			r.iPos = r.iN
+		case readStateCR:
+			if r.input[r.iPos] == newLine {
+				r.iPos++
+				r.crlf = true
+				r.state = readStateEnd
+				continue
+			}
+			// not a blank line, output the "\r" and the rest of the line
+			p[i] = carriageReturn
+			i++
+			n++
+			r.state = readStateCopy
 		}
 	}
 	return n, nil
 }
 
+// boundary returns true if the "From " that was matched after a blank line starts a message, in strict mode.
+// The rest of the line must be a valid envelope, followed by header fields if checkHeaders
+func (r *decoder) boundary() bool {
+	end := r.peekLine(0)
+	if end == -1 {
+		return false
+	}
+	line := r.input[r.iPos : r.iPos+end-1]
+	if _, err := ParseEnvelope(header+string(line), r.layouts...); err != nil {
+		return false
+	}
+	if !r.checkHeaders {
+		return true
+	}
+	for first := true; ; first = false {
+		start := end
+		if end = r.peekLine(start); end == -1 {
+			// the end of the stream, or too long to tell
+			return !first
+		}
+		line = r.input[r.iPos+start : r.iPos+end]
+		if blankLine(line) {
+			return !first
+		}
+		if !headerLine(line, first) {
+			return false
+		}
+	}
+}
+
+// headerLine returns true if line is a header field, or the continuation of the previous one if not first
+func headerLine(line []byte, first bool) bool {
+	if line[0] == ' ' || line[0] == '\t' {
+		return !first
+	}
+	for i, c := range line {
+		if c == ':' {
+			return i > 0
+		}
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return false
+}
+
+// peekLine returns the offset after the eol of the line at input[iPos+off], relative to iPos, reading more
+// input if needed. -1 is returned if the line doesn't end before the end of the stream or maxPeek
+func (r *decoder) peekLine(off int) int {
+	for {
+		if i := bytes.IndexByte(r.input[r.iPos+off:r.iN], newLine); i != -1 {
+			return off + i + 1
+		}
+		if r.err != nil || r.iN-r.iPos >= maxPeek {
+			return -1
+		}
+		r.fill()
+	}
+}
+
+// fill moves the unprocessed input to the start of the buffer, and reads more input after it
+func (r *decoder) fill() {
+	r.lineAt(r.iPos)
+	r.pos += int64(r.iPos)
+	r.iN = copy(r.input, r.input[r.iPos:r.iN])
+	r.iPos = 0
+	r.counted = 0
+	if r.iN == len(r.input) {
+		r.input = append(r.input, make([]byte, len(r.input))...)
+	}
+	var n int
+	n, r.err = r.r.Read(r.input[r.iN:])
+	r.iN += n
+}
+
+// recoverEOF ends the stream when it ended in a state other than readStateEnd, in recovery mode.
+// Anything that was matched but not output yet is kept in tail
+func (r *decoder) recoverEOF() {
+	switch r.state {
+	case readStateHeaderMagic, readStateSkip:
+		// an empty stream, or garbage after the last message was skipped
+		if r.matches > 0 && !r.skipping {
+			err := r.parseError(InvalidFormat)
+			err.Offset, err.Line = r.start, r.startLine
+			r.reportError(err)
+		}
+	case readStateNextRecord, readStateHeaderValues:
+		// the "From " line is not followed by an eol, it's a message without content
+		if r.state == readStateNextRecord {
+			r.header.Reset()
+		}
+		r.reportError(r.parseError(InvalidHeader))
+		r.headerOnly = false
+	default:
+		// the blank line is missing after the last message
+		r.reportError(r.parseError(InvalidFormat))
+		switch r.state {
+		case readStateCR:
+			r.tail = append(r.tail, carriageReturn)
+		case readStateHeaderMagicEOF, readStatePutEol:
+			if r.crlf {
+				r.tail = append(r.tail, carriageReturn)
+			}
+			r.tail = append(r.tail, newLine)
+			fallthrough
+		case readStateStartLine, readStateMatchFrom, readStateOutputFrom:
+			r.tail = append(r.tail, bytes.Repeat([]byte{escape}, r.escapeCount)...)
+			r.tail = append(r.tail, header[r.hPos:r.hPos+r.matches]...)
+		}
+	}
+	r.state = readStateEnd
+	r.matches = 0
+	r.escapeCount = 0
+	r.hPos = 0
+	r.skipping = false
+	r.crlf = false
+}
+
 // Close closes the stream and resets all state
 func (r *decoder) Close() error {
 	r.header.Reset()
 	r.iN = 0
 	r.iPos = 0
+	r.pos = 0
+	r.lines = 0
+	r.counted = 0
+	r.messages = 0
+	r.skipping = false
+	r.tail = nil
+	r.crlf = false
+	r.out = nil
+	r.outErr = nil
+	r.outCR = false
 	r.state = readStateHeaderMagic
 	return nil
 }
 
-// Header returns the parsed header values, from and date
-// err is returned if the date is invalid (Not time.ANSIC)
-func (r *decoder) Header() (err error, from string, date time.Time) {
-	if r.header.Len() > 0 {
-		s := r.header.String()
-		if i := strings.Index(s, " "); i != -1 {
-			from = s[:i]
-			if len(s)-1 > i+1 {
-				date, err = time.Parse(time.ANSIC, s[i+1:])
-			}
-			if err == nil {
-				return
+// next reads the "From " line of the next message, so that Header can be called
+// before any of the message is read. io.EOF is returned if there are no more messages
+func (r *decoder) next() error {
+	switch r.state {
+	case readStateHeaderMagic, readStateNextRecord:
+	case readStateEnd:
+		if r.iPos == r.iN && r.err == io.EOF {
+			return io.EOF
+		}
+		return r.parseError(InvalidFormat)
+	default:
+		return r.parseError(InvalidFormat)
+	}
+	if r.input == nil {
+		r.input = make([]byte, defaultBufferSize)
+	}
+	var scratch [1]byte
+	r.headerOnly = true
+	defer func() {
+		r.headerOnly = false
+	}()
+	for r.headerOnly {
+		if _, err := r.read(scratch[:]); err != nil {
+			if err == io.EOF && !r.headerOnly {
+				// the stream ended after the "From " line, in recovery mode
+				return nil
 			}
+			return err
 		}
 	}
-	err = InvalidHeader
-	return
+	return nil
+}
+
+// Envelope returns the "From " line of the current message.
+// A *ParseError wrapping InvalidHeader is returned if the line could not be parsed
+func (r *decoder) Envelope() (Envelope, error) {
+	e, err := ParseEnvelope(header+r.header.String(), r.layouts...)
+	e.Offset = r.start
+	if err != nil {
+		err = &ParseError{Err: err, Offset: r.start, Line: r.startLine, Message: max(r.messages-1, 0), State: r.state.String()}
+	}
+	return e, err
+}
+
+// envelopeLength returns the length of the "From " line of the current message as it is in the stream,
+// including its eol
+func (r *decoder) envelopeLength() int64 {
+	return int64(len(header) + r.header.Len() + 1)
+}
+
+// lineAt returns the line number of input[i], i must not be less than in the previous call since the input
+// was read
+func (r *decoder) lineAt(i int) int64 {
+	if i > r.counted {
+		r.lines += int64(bytes.Count(r.input[r.counted:i], eol))
+		r.counted = i
+	}
+	return r.lines + 1
+}
+
+// parseError returns a *ParseError for err, at the current position
+func (r *decoder) parseError(err error) *ParseError {
+	return &ParseError{
+		Err:     err,
+		Offset:  r.pos + int64(r.iPos),
+		Line:    r.lineAt(r.iPos),
+		Message: max(r.messages-1, 0),
+		State:   r.state.String(),
+	}
+}
+
+// Header returns the parsed header values, from and date
+// err is returned if the date is invalid (see WithDateLayouts)
+//
+// Deprecated: use Envelope
+func (r *decoder) Header() (err error, from string, date time.Time) {
+	e, err := r.Envelope()
+	return err, e.From, e.Date
+}
+
+// contentLengthField is the name of the Content-Length header, with the colon
+const contentLengthField = "Content-Length:"
+
+// isField returns true if the header line is for the field, which includes the colon
+func isField(line []byte, field string) bool {
+	return len(line) >= len(field) && strings.EqualFold(string(line[:len(field)]), field)
+}
+
+// contentLength parses a Content-Length header line
+func contentLength(line []byte) (int64, bool) {
+	if !isField(line, contentLengthField) {
+		return 0, false
+	}
+	cl, err := strconv.ParseInt(strings.TrimSpace(string(line[len(contentLengthField):])), 10, 64)
+	if err != nil || cl < 0 {
+		return 0, false
+	}
+	return cl, true
 }
@@ -2,7 +2,10 @@ package mbox
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -70,7 +73,7 @@ func TestReadMagic(t *testing.T) {
 	var b bytes.Buffer
 	r := NewReader(bytes.NewReader([]byte(readTest1)))
 	_, err := io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
-	if err != InvalidHeader {
+	if !errors.Is(err, InvalidHeader) {
 		t.Error("InvalidHeader expected")
 	}
 	err = r.Close()
@@ -85,7 +88,7 @@ func TestReadHeader(t *testing.T) {
 	r := NewReader(bytes.NewReader([]byte(readTest2)))
 	_, err := io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
 
-	if err != InvalidFormat {
+	if !errors.Is(err, InvalidFormat) {
 		t.Error(err)
 	}
 
@@ -117,8 +120,8 @@ func TestReadLastLine(t *testing.T) {
 		t.Error(err)
 	}
 
-	if i != 41 {
-		t.Error("expecting 41 characters")
+	if i != 42 {
+		t.Error("expecting 42 characters")
 	}
 
 	err, from, time := r.Header()
@@ -148,7 +151,7 @@ func TestReadMulti(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if i != 41 {
+	if i != 42 {
 		t.Error("expecting 42 characters")
 	}
 
@@ -173,7 +176,7 @@ func TestReadMulti(t *testing.T) {
 		t.Error(err)
 	}
 	if i != 17 {
-		t.Error("expecting 42 characters")
+		t.Error("expecting 17 characters")
 	}
 
 	err = r.Close()
@@ -193,8 +196,8 @@ func TestReadMSingle(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if i != 104 {
-		t.Error("expecting 104 characters")
+	if i != 106 {
+		t.Error("expecting 106 characters")
 	}
 
 	err, from, time := r.Header()
@@ -230,6 +233,61 @@ func TestReadMSingle(t *testing.T) {
 	//fmt.Print("[" + result + "]")
 }
 
+// readSize reads all of r into a p of size bytes
+func readSize(r io.Reader, size int) (string, error) {
+	var b bytes.Buffer
+	p := make([]byte, size)
+	for {
+		n, err := r.Read(p)
+		b.Write(p[:n])
+		if err == io.EOF {
+			return b.String(), nil
+		}
+		if err != nil {
+			return b.String(), err
+		}
+	}
+}
+
+func TestReadDecoder(t *testing.T) {
+	const from = "From test@example.com Wed Jan 27 02:32:22 2021\n"
+	tests := []struct {
+		name     string
+		in       string
+		size     int
+		expected string
+	}{
+		// the byte after an unescaped "From " was dropped
+		{"unescaped", from + ">From here\n\n", 64, "From here\n"},
+		// the blank line before a line that's not "From " was not counted
+		{"blank line", from + "one\n\ntwo\n\n", 64, "one\n\ntwo\n"},
+		// the rest of the escape pattern was lost when p was full
+		{"small p", from + ">>>From here\n\n", 2, ">>From here\n"},
+		// several blank lines before a "From " line were lost
+		{"blank lines", from + "one\n\n\n" + from + "two\n\n", 64, "one\n\n"},
+	}
+	for _, test := range tests {
+		out, err := readSize(NewReader(strings.NewReader(test.in)), test.size)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if out != test.expected {
+			t.Errorf("%s: unexpected result %q", test.name, out)
+		}
+	}
+}
+
+func TestReadAfterError(t *testing.T) {
+	r := NewReader(strings.NewReader(readTest2))
+	if _, err := readSize(r, 8); !errors.Is(err, InvalidFormat) {
+		t.Error("expecting InvalidFormat, got", err)
+	}
+	// the error is returned again, not (0, nil) forever
+	if _, err := r.Read(make([]byte, 8)); !errors.Is(err, InvalidFormat) {
+		t.Error("expecting InvalidFormat again, got", err)
+	}
+}
+
 // Only the stuffing >>> and no "From "
 func TestRead6(t *testing.T) {
 	buf := make([]byte, 8)
@@ -269,7 +327,7 @@ func TestReadBadHeader(t *testing.T) {
 	r := NewReader(bytes.NewReader([]byte(readTest7)))
 	_, err := io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
 
-	if err != InvalidFormat {
+	if !errors.Is(err, InvalidFormat) {
 		t.Error(err)
 	}
 
@@ -286,7 +344,7 @@ func TestReadBadHeader2(t *testing.T) {
 	}
 
 	err, _, _ = r.Header()
-	if err != InvalidHeader {
+	if !errors.Is(err, InvalidHeader) {
 		t.Error("expecting InvalidHeader error")
 	}
 
@@ -301,8 +359,170 @@ func TestReadBadHeader2(t *testing.T) {
 
 	_, err = io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
 
-	if err != InvalidFormat {
+	if !errors.Is(err, InvalidFormat) {
 		t.Error("InvalidFormat error expected")
 	}
 
 }
+
+// an escaped line after a blank line
+const readTest10 = `From test@example.com Wed Jan 27 02:32:22 2021
+Subject: test
+
+>From this should be unescaped
+`
+
+func TestReadEscapedAfterBlank(t *testing.T) {
+	buf := make([]byte, 8)
+	var b bytes.Buffer
+	r := NewReader(bytes.NewReader([]byte(readTest10 + "\n")))
+	_, err := io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		t.Error(err)
+	}
+	if b.String() != "Subject: test\n\nFrom this should be unescaped\n" {
+		t.Error("unexpected result", b.String())
+	}
+}
+
+// recoverAll reads all the messages of in with WithRecovery, and returns their bodies and the problems reported
+func recoverAll(t *testing.T, in string) ([]Envelope, []string, []*ParseError) {
+	var reports []*ParseError
+	s := NewScanner(strings.NewReader(in), WithRecovery(), WithReport(func(err *ParseError) {
+		reports = append(reports, err)
+	}))
+	var envelopes []Envelope
+	var bodies []string
+	for s.Next() {
+		b, err := io.ReadAll(s.Message().Body)
+		if err != nil {
+			t.Error(err)
+		}
+		envelopes = append(envelopes, s.Message().Envelope)
+		bodies = append(bodies, string(b))
+	}
+	if s.Err() != nil {
+		t.Error("unexpected error", s.Err())
+	}
+	return envelopes, bodies, reports
+}
+
+func TestRecovery(t *testing.T) {
+	const from = "From a@example.com Wed Jan 27 02:32:22 2021\n"
+	tests := []struct {
+		name    string
+		in      string
+		bodies  []string
+		reports []error
+	}{
+		{"empty", "", nil, nil},
+		{"garbage", "garbage\nFrom\n" + from + "one\n\n" + from + "two\n\n",
+			[]string{"one\n", "two\n"}, []error{InvalidFormat}},
+		{"no blank line", from + "one\n\n" + from + "two\n", []string{"one\n", "two\n"}, []error{InvalidFormat}},
+		{"no eol", from + "one", []string{"one"}, []error{InvalidFormat}},
+		{"escaped", from + "one\n>Fro", []string{"one\n>Fro"}, []error{InvalidFormat}},
+		{"partial From", from + "one\n\nFro", []string{"one\n\nFro"}, []error{InvalidFormat}},
+		{"no content", from + "one\n\n" + from[:len(from)-1], []string{"one\n", ""}, []error{InvalidHeader}},
+		{"bad envelope", from + "one\n\nFrom a@example.com bad date\ntwo\n\n" + from + "three\n\n",
+			[]string{"one\n", "two\n", "three\n"}, []error{InvalidHeader}},
+	}
+	for _, test := range tests {
+		_, bodies, reports := recoverAll(t, test.in)
+		if !slices.Equal(bodies, test.bodies) {
+			t.Errorf("%s: unexpected bodies %q", test.name, bodies)
+		}
+		if len(reports) != len(test.reports) {
+			t.Errorf("%s: unexpected reports %v", test.name, reports)
+			continue
+		}
+		for i := range reports {
+			if !errors.Is(reports[i], test.reports[i]) {
+				t.Errorf("%s: unexpected report %v", test.name, reports[i])
+			}
+		}
+	}
+}
+
+func TestRecoveryReport(t *testing.T) {
+	const from = "From a@example.com Wed Jan 27 02:32:22 2021\n"
+	envelopes, _, reports := recoverAll(t, "garbage\nFrom a@example.com bad date\nbody\n\n"+from+"two\n")
+	if len(envelopes) != 2 || envelopes[0].From != "a@example.com" || !envelopes[0].Date.IsZero() {
+		t.Fatal("unexpected envelopes", envelopes)
+	}
+	if len(reports) != 3 {
+		t.Fatal("expecting 3 reports, got", reports)
+	}
+	if reports[0].Offset != 0 || reports[0].Line != 1 || reports[0].State != "HeaderMagic" {
+		t.Error("unexpected report for the garbage", reports[0])
+	}
+	if reports[1].Offset != 8 || reports[1].Line != 2 || !errors.Is(reports[1], InvalidHeader) {
+		t.Error("unexpected report for the envelope", reports[1])
+	}
+	if reports[2].Message != 1 || !errors.Is(reports[2], InvalidFormat) {
+		t.Error("unexpected report for the end", reports[2])
+	}
+}
+
+func TestStrict(t *testing.T) {
+	const from = "From a@example.com Wed Jan 27 02:32:22 2021\n"
+	const in = from + "Subject: one\n\nbody\n\nFrom here on, it's not escaped\n\n" +
+		from + "not a header\n\n" + from + "Subject: two\n\ntwo\n\n"
+	tests := []struct {
+		name   string
+		opts   []Option
+		bodies []string
+		lines  []int64
+	}{
+		{"strict", []Option{WithStrict(false)},
+			[]string{"Subject: one\n\nbody\n\nFrom here on, it's not escaped\n", "not a header\n", "Subject: two\n\ntwo\n"},
+			[]int64{6}},
+		{"headers", []Option{WithStrict(true)},
+			[]string{"Subject: one\n\nbody\n\nFrom here on, it's not escaped\n\n" + from + "not a header\n", "Subject: two\n\ntwo\n"},
+			[]int64{6, 8}},
+	}
+	for _, test := range tests {
+		var lines []int64
+		opts := append(test.opts, WithReport(func(err *ParseError) {
+			if !errors.Is(err, UnescapedFrom) || err.Message != 0 {
+				t.Errorf("%s: unexpected report %v", test.name, err)
+			}
+			lines = append(lines, err.Line)
+		}))
+		s := NewScanner(strings.NewReader(in), opts...)
+		var bodies []string
+		for s.Next() {
+			b, _ := io.ReadAll(s.Message().Body)
+			bodies = append(bodies, string(b))
+		}
+		if s.Err() != nil {
+			t.Error(s.Err())
+		}
+		if !slices.Equal(bodies, test.bodies) {
+			t.Errorf("%s: unexpected bodies %q", test.name, bodies)
+		}
+		if !slices.Equal(lines, test.lines) {
+			t.Errorf("%s: unexpected reports at lines %v", test.name, lines)
+		}
+	}
+}
+
+func TestStrictSmallBuffer(t *testing.T) {
+	const from = "From a@example.com Wed Jan 27 02:32:22 2021\n"
+	buf := make([]byte, 8)
+	var b bytes.Buffer
+	r := NewReader(strings.NewReader(from+"one\n\n"+from+"two\n\n"+from+"Subject: three\n\n"), WithStrict(true))
+	_, err := io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		t.Error(err)
+	}
+	if b.String() != "one\n\n"+from+"two\n" {
+		t.Errorf("unexpected result %q", b.String())
+	}
+	if err = r.next(); err != nil {
+		t.Error(err)
+	}
+	e, err := r.Envelope()
+	if err != nil || e.Offset != int64(2*len(from)+10) {
+		t.Error("unexpected envelope", e, err)
+	}
+}
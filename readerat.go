@@ -0,0 +1,176 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+// InvalidOffset is returned by Seek for a negative offset or an invalid whence
+var InvalidOffset = errors.New("invalid offset")
+
+// ReaderAt reads messages starting from any offset of a mailbox.
+// An offset that is not at the start of a message is resynchronised to the next "From " line that follows
+// a blank line and has a valid date. For mboxcl and mboxcl2, which do not escape all "From " lines, this
+// could find a line inside a message
+type ReaderAt struct {
+	r    io.ReaderAt
+	size int64
+	// pos is the offset given to Seek, or the offset of the last message returned by Next
+	pos int64
+	// s reads messages after base, it's nil after Seek
+	s    *Scanner
+	base int64
+
+	// opts are given to the scanners
+	opts []Option
+	config
+}
+
+// NewReaderAt returns a ReaderAt for the mailbox r of size bytes. The options are used to read the messages,
+// the Line and Message of a ParseError count from the message where reading started
+func NewReaderAt(r io.ReaderAt, size int64, opts ...Option) *ReaderAt {
+	return &ReaderAt{r: r, size: size, opts: opts, config: newConfig(opts)}
+}
+
+// Size returns the size of the mailbox
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+// Sync returns the offset of the first message at or after offset.
+// io.EOF is returned together with the size if there are no more messages
+func (r *ReaderAt) Sync(offset int64) (int64, error) {
+	pos, _, err := r.sync(offset)
+	return pos, err
+}
+
+// sync is like Sync, and also returns the envelope of the message
+func (r *ReaderAt) sync(offset int64) (int64, Envelope, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= r.size {
+		return r.size, Envelope{}, io.EOF
+	}
+	// start three bytes earlier, to tell if offset follows a blank line, which may be "\r\n"
+	pos := max(offset-3, 0)
+	br := bufio.NewReaderSize(io.NewSectionReader(r.r, pos, r.size-pos), defaultBufferSize)
+	lineStart, afterBlank := pos == 0, pos == 0
+	for {
+		line, err := br.ReadSlice(newLine)
+		if len(line) > 0 {
+			if lineStart && afterBlank && pos >= offset && bytes.HasPrefix(line, []byte(header)) {
+				if e, perr := ParseEnvelope(string(line), r.layouts...); perr == nil {
+					e.Offset = pos
+					return pos, e, nil
+				}
+			}
+			afterBlank = lineStart && blankLine(line)
+			lineStart = line[len(line)-1] == newLine
+			pos += int64(len(line))
+		}
+		if err == io.EOF {
+			return r.size, Envelope{}, io.EOF
+		} else if err != nil && err != bufio.ErrBufferFull {
+			return pos, Envelope{}, err
+		}
+	}
+}
+
+// MessageAt returns the first message at or after offset. The body can be read until the end of the message.
+// io.EOF is returned if there are no more messages
+func (r *ReaderAt) MessageAt(offset int64) (*Message, error) {
+	pos, err := r.Sync(offset)
+	if err != nil {
+		return nil, err
+	}
+	s := r.scanner(pos, r.size)
+	if !s.Next() {
+		if s.Err() != nil {
+			return nil, s.Err()
+		}
+		return nil, io.EOF
+	}
+	m := s.Message()
+	m.Envelope.Offset += pos
+	return m, nil
+}
+
+// scanner returns a Scanner that reads the mailbox from pos to end
+func (r *ReaderAt) scanner(pos, end int64) *Scanner {
+	opts := r.opts
+	if r.report != nil {
+		// the problems are reported with their offset in the mailbox
+		opts = append(opts[:len(opts):len(opts)], WithReport(func(err *ParseError) {
+			err.Offset += pos
+			r.report(err)
+		}))
+	}
+	return NewScanner(io.NewSectionReader(r.r, pos, end-pos), opts...)
+}
+
+// Seek sets the offset for Next, like io.Seeker. io.SeekCurrent is relative to the offset given to the last
+// Seek, or the offset of the last message returned by Next
+func (r *ReaderAt) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return r.pos, InvalidOffset
+	}
+	if offset < 0 {
+		return r.pos, InvalidOffset
+	}
+	r.pos = offset
+	r.s = nil
+	return offset, nil
+}
+
+// Next returns the next message, starting with the first message at or after the offset given to Seek.
+// The body is only valid until the next call to Next or Seek. io.EOF is returned if there are no more messages
+func (r *ReaderAt) Next() (*Message, error) {
+	if r.s == nil {
+		pos, err := r.Sync(r.pos)
+		if err != nil {
+			return nil, err
+		}
+		r.s = r.scanner(pos, r.size)
+		r.base = pos
+	}
+	if !r.s.Next() {
+		if r.s.Err() != nil {
+			return nil, r.s.Err()
+		}
+		return nil, io.EOF
+	}
+	m := r.s.Message()
+	m.Envelope.Offset += r.base
+	r.pos = m.Envelope.Offset
+	return m, nil
+}
+
+// Search returns the offset of the first message with a date that's not before t, using a binary search.
+// The messages must be in the order of their dates, such as in a mailbox that's only appended to.
+// io.EOF is returned together with the size if all messages are before t
+func (r *ReaderAt) Search(t time.Time) (int64, error) {
+	lo, hi := int64(0), r.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		pos, e, err := r.sync(mid)
+		if err == io.EOF || (err == nil && !e.Date.Before(t)) {
+			hi = mid
+		} else if err != nil {
+			return pos, err
+		} else {
+			// every offset up to pos finds the same message
+			lo = pos + 1
+		}
+	}
+	return r.Sync(lo)
+}
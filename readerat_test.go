@@ -0,0 +1,155 @@
+package mbox
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const readerAtTest1 = `From a@example.com Wed Jan 27 02:32:22 2021
+Subject: one
+
+body
+From the start, not a boundary
+>From escaped
+
+From b@example.com Thu Jan 28 02:32:22 2021
+Subject: two
+
+two
+
+From c@example.com Fri Jan 29 02:32:22 2021
+Subject: three
+
+three
+
+`
+
+func TestReaderAtSync(t *testing.T) {
+	r := NewReaderAt(strings.NewReader(readerAtTest1), int64(len(readerAtTest1)))
+	second := int64(strings.Index(readerAtTest1, "From b@"))
+	third := int64(strings.Index(readerAtTest1, "From c@"))
+	tests := []struct {
+		offset, expected int64
+	}{{0, 0}, {1, second}, {second - 1, second}, {second, second}, {second + 1, third}, {third, third}}
+	for _, test := range tests {
+		if pos, err := r.Sync(test.offset); err != nil || pos != test.expected {
+			t.Error(test.offset, "expecting", test.expected, "got", pos, err)
+		}
+	}
+	if pos, err := r.Sync(third + 1); err != io.EOF || pos != r.Size() {
+		t.Error("expecting io.EOF, got", pos, err)
+	}
+}
+
+func TestReaderAtSyncCRLF(t *testing.T) {
+	r := NewReaderAt(strings.NewReader(crlfTest), int64(len(crlfTest)))
+	second := int64(strings.Index(crlfTest, "From b@"))
+	for _, offset := range []int64{5, second - 2, second} {
+		if pos, err := r.Sync(offset); err != nil || pos != second {
+			t.Error(offset, "expecting", second, "got", pos, err)
+		}
+	}
+	m, err := r.MessageAt(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Envelope.From != "b@example.com" || m.Envelope.Offset != second {
+		t.Error("unexpected envelope", m.Envelope)
+	}
+}
+
+// the options other than the variant and the layouts are used too
+func TestReaderAtOptions(t *testing.T) {
+	r := NewReaderAt(strings.NewReader(crlfTest), int64(len(crlfTest)), WithLineEnding(LineEndingLF))
+	m, err := r.MessageAt(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(m.Body); err != nil || string(body) != "Subject: two\n\ntwo\n" {
+		t.Errorf("unexpected body %q %v", body, err)
+	}
+
+	// the last message doesn't end with a blank line
+	in := strings.TrimSuffix(readerAtTest1, "\n")
+	var reported []*ParseError
+	r = NewReaderAt(strings.NewReader(in), int64(len(in)), WithRecovery(), WithReport(func(err *ParseError) {
+		reported = append(reported, err)
+	}))
+	third := int64(strings.Index(in, "From c@"))
+	if m, err = r.MessageAt(third); err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(m.Body); err != nil || string(body) != "Subject: three\n\nthree\n" {
+		t.Errorf("unexpected body %q %v", body, err)
+	}
+	if len(reported) != 1 || reported[0].Offset < third {
+		t.Error("expecting a problem in the third message", reported)
+	}
+}
+
+func TestReaderAtMessageAt(t *testing.T) {
+	r := NewReaderAt(strings.NewReader(readerAtTest1), int64(len(readerAtTest1)))
+	m, err := r.MessageAt(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Envelope.From != "b@example.com" || m.Envelope.Offset != int64(strings.Index(readerAtTest1, "From b@")) {
+		t.Error("unexpected envelope", m.Envelope)
+	}
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	if string(body) != "Subject: two\n\ntwo\n" {
+		t.Error("unexpected body", string(body))
+	}
+}
+
+func TestReaderAtNext(t *testing.T) {
+	r := NewReaderAt(strings.NewReader(readerAtTest1), int64(len(readerAtTest1)))
+	var from []string
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		from = append(from, m.Envelope.From)
+	}
+	if strings.Join(from, " ") != "a@example.com b@example.com c@example.com" {
+		t.Error("unexpected messages", from)
+	}
+	// go back to the second message
+	if _, err := r.Seek(-20, io.SeekCurrent); err != nil {
+		t.Error(err)
+	}
+	if m, err := r.Next(); err != nil || m.Envelope.From != "c@example.com" {
+		t.Error("expecting the last message", err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err != InvalidOffset {
+		t.Error("expecting InvalidOffset, got", err)
+	}
+}
+
+func TestReaderAtSearch(t *testing.T) {
+	r := NewReaderAt(strings.NewReader(readerAtTest1), int64(len(readerAtTest1)))
+	tests := []struct {
+		date     time.Time
+		expected int64
+	}{
+		{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+		{time.Date(2021, 1, 28, 2, 32, 22, 0, time.UTC), int64(strings.Index(readerAtTest1, "From b@"))},
+		{time.Date(2021, 1, 28, 3, 0, 0, 0, time.UTC), int64(strings.Index(readerAtTest1, "From c@"))},
+	}
+	for _, test := range tests {
+		if pos, err := r.Search(test.date); err != nil || pos != test.expected {
+			t.Error(test.date, "expecting", test.expected, "got", pos, err)
+		}
+	}
+	if pos, err := r.Search(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)); err != io.EOF || pos != r.Size() {
+		t.Error("expecting io.EOF, got", pos, err)
+	}
+}
@@ -0,0 +1,256 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// repairWindow is how much of the end of the mailbox is read at first by CheckTail
+const repairWindow = 64 * 1024
+
+// errTailTooLong is returned by checkTail if the last message is longer than its limit
+var errTailTooLong = errors.New("the last message is too long")
+
+// RepairAction is what was done to the end of a mailbox by Repair
+type RepairAction int
+
+const (
+	// RepairNone means that the mailbox ends with a complete message
+	RepairNone RepairAction = iota
+	// RepairClosed means that the blank line after the last message was added
+	RepairClosed
+	// RepairTruncated means that the incomplete last message was removed
+	RepairTruncated
+)
+
+// repairActionNames are the names of the actions, for String
+var repairActionNames = []string{
+	RepairNone:      "none",
+	RepairClosed:    "closed",
+	RepairTruncated: "truncated",
+}
+
+// String returns the name of the action
+func (a RepairAction) String() string {
+	if a < 0 || int(a) >= len(repairActionNames) {
+		return fmt.Sprintf("RepairAction(%d)", int(a))
+	}
+	return repairActionNames[a]
+}
+
+// RepairReport describes the end of a mailbox, and what Repair did to it
+type RepairReport struct {
+	// Action is what was done
+	Action RepairAction
+	// Problem describes what was wrong, it's empty for RepairNone
+	Problem string
+	// Offset is where the mailbox was truncated or the blank line was added
+	Offset int64
+	// Removed is the number of bytes removed, and Added the number of bytes added
+	Removed int64
+	Added   int64
+	// Envelope is the envelope of the last message, or of the message that was removed.
+	// It's incomplete if the "From " line was incomplete
+	Envelope Envelope
+}
+
+// String describes what was done
+func (r *RepairReport) String() string {
+	switch r.Action {
+	case RepairClosed:
+		return fmt.Sprintf("closed at offset %d, adding %d bytes: %s", r.Offset, r.Added, r.Problem)
+	case RepairTruncated:
+		return fmt.Sprintf("truncated at offset %d, removing %d bytes: %s", r.Offset, r.Removed, r.Problem)
+	}
+	return "no repair needed"
+}
+
+// CheckTail examines the end of the mailbox r of size bytes, and returns what Repair would do to it, without
+// changing it. A last message that was cut short, in the middle of a line, before its Content-Length, or
+// right after its "From " line, is removed. A last message that's only missing the blank line after it is
+// closed. InvalidFormat is returned if no message was found.
+// Only the variants with a "From " line can be checked
+func CheckTail(r io.ReaderAt, size int64, opts ...Option) (*RepairReport, error) {
+	return checkTail(r, size, newConfig(opts), size)
+}
+
+// checkTail is CheckTail, reading at most limit bytes of the end of r. errTailTooLong is returned if the
+// last message doesn't begin in the limit
+func checkTail(r io.ReaderAt, size int64, c config, limit int64) (*RepairReport, error) {
+	if !hasEnvelope(c.variant) {
+		return nil, InvalidFormat
+	}
+	rep := &RepairReport{Offset: size}
+	if size == 0 {
+		return rep, nil
+	}
+	for window := min(int64(repairWindow), limit); ; window = min(window*2, limit) {
+		base := max(size-window, 0)
+		data := make([]byte, size-base)
+		if _, err := r.ReadAt(data, base); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if rep.check(data, base, c) {
+			return rep, nil
+		}
+		if base == 0 {
+			return nil, InvalidFormat
+		}
+		if window == limit {
+			return nil, errTailTooLong
+		}
+	}
+}
+
+// check works out the repair from data, the end of the mailbox from base.
+// It returns false if data doesn't go back far enough
+func (rep *RepairReport) check(data []byte, base int64, c config) bool {
+	size := base + int64(len(data))
+	// end is after the last complete line
+	end := len(data)
+	if data[end-1] != newLine {
+		i := bytes.LastIndexByte(data, newLine)
+		if i == -1 && base > 0 {
+			return false
+		}
+		if line := data[i+1:]; bytes.HasPrefix(line, []byte(header)) && afterBlank(data, i+1, base) {
+			rep.Envelope, _ = ParseEnvelope(string(line), c.layouts...)
+			rep.Envelope.Offset = base + int64(i+1)
+			rep.truncate(rep.Envelope.Offset, size, "the last \"From \" line is incomplete")
+			return true
+		}
+		end = i + 1
+	}
+	start, ok := lastBoundary(data[:end], base, c.layouts)
+	if !ok {
+		return false
+	}
+	msg := data[start:]
+	eol := bytes.IndexByte(msg, newLine)
+	rep.Envelope, _ = ParseEnvelope(string(msg[:eol]), c.layouts...)
+	rep.Envelope.Offset = base + int64(start)
+	content := msg[eol+1:]
+	switch {
+	case end < len(data):
+		rep.truncate(rep.Envelope.Offset, size, "the last line is incomplete")
+	case len(content) == 0:
+		rep.truncate(rep.Envelope.Offset, size, "the last message has no content")
+	case shortContentLength(content, c.variant):
+		rep.truncate(rep.Envelope.Offset, size, "the last message is shorter than its Content-Length")
+	case bytes.HasSuffix(msg, []byte{newLine, newLine}) || bytes.HasSuffix(msg, []byte{newLine, carriageReturn, newLine}):
+		// a complete message
+	default:
+		rep.Action = RepairClosed
+		rep.Problem = "the blank line after the last message is missing"
+		rep.Added = 1
+		if bytes.HasSuffix(msg, []byte{carriageReturn, newLine}) {
+			rep.Added = 2
+		}
+	}
+	return true
+}
+
+// truncate sets the report for truncating the mailbox of size bytes at offset
+func (rep *RepairReport) truncate(offset, size int64, problem string) {
+	rep.Action = RepairTruncated
+	rep.Problem = problem
+	rep.Offset = offset
+	rep.Removed = size - offset
+}
+
+// afterBlank returns true if data[i] is at the start of a line that follows a blank line, or at the start of
+// the mailbox. base is the offset of data in the mailbox
+func afterBlank(data []byte, i int, base int64) bool {
+	switch {
+	case i == 0:
+		return base == 0
+	case i == 1:
+		return base == 0 && data[0] == newLine
+	}
+	return data[i-1] == newLine && (data[i-2] == newLine || (data[i-2] == carriageReturn && i >= 3 && data[i-3] == newLine))
+}
+
+// lastBoundary returns the index of the last "From " line of data that follows a blank line and has a valid
+// envelope. base is the offset of data in the mailbox. false is returned if there's none
+func lastBoundary(data []byte, base int64, layouts []string) (int, bool) {
+	for end := len(data); end >= 0; {
+		start := bytes.LastIndex(data[:end], []byte("\n"+header)) + 1
+		if start == 0 && !bytes.HasPrefix(data, []byte(header)) {
+			return 0, false
+		}
+		if afterBlank(data, start, base) {
+			line := data[start:]
+			if i := bytes.IndexByte(line, newLine); i != -1 {
+				if _, err := ParseEnvelope(string(line[:i]), layouts...); err == nil {
+					return start, true
+				}
+			}
+		}
+		end = start - 1
+	}
+	return 0, false
+}
+
+// shortContentLength returns true if the content of the last message is shorter than its Content-Length,
+// for mboxcl and mboxcl2
+func shortContentLength(content []byte, v Variant) bool {
+	if v != MboxCL && v != MboxCL2 {
+		return false
+	}
+	h, body := headerEnd(content)
+	if h == -1 {
+		return false
+	}
+	cl := int64(-1)
+	fields(content[:h+1], func(field []byte) bool {
+		if n, ok := contentLength(field); ok {
+			cl = n
+		}
+		return true
+	})
+	return cl > int64(len(content)-body)
+}
+
+// Repair checks the end of the mailbox at path with CheckTail, and repairs it. The locks given by WithLocks
+// are taken first, like AppendFile, and the file is synced after it was changed
+func Repair(path string, opts ...Option) (*RepairReport, error) {
+	f, err := lockFile(path, os.O_RDWR, newConfig(opts))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	var rep *RepairReport
+	if err == nil {
+		rep, err = CheckTail(f, fi.Size(), opts...)
+	}
+	if err == nil {
+		err = rep.apply(f.File)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// apply changes f as described by the report
+func (rep *RepairReport) apply(f *os.File) error {
+	var err error
+	switch rep.Action {
+	case RepairTruncated:
+		err = f.Truncate(rep.Offset)
+	case RepairClosed:
+		_, err = f.WriteAt([]byte("\r\n"[2-rep.Added:]), rep.Offset)
+	default:
+		return nil
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package mbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckTail(t *testing.T) {
+	const from = "From a@example.com Wed Jan 27 02:32:22 2021\n"
+	const good = from + "Subject: one\n\none\n\n"
+	long := from + "Subject: long\n\n" + strings.Repeat("long\n", 30000)
+	tests := []struct {
+		name    string
+		in      string
+		variant Variant
+		action  RepairAction
+		offset  int64
+		added   int64
+	}{
+		{"empty", "", MboxRD, RepairNone, 0, 0},
+		{"complete", good + from + "Subject: two\n\ntwo\n\n", MboxRD, RepairNone, int64(2 * len(good)), 0},
+		{"no blank line", good + from + "Subject: two\n\ntwo\n", MboxRD, RepairClosed, int64(2*len(good)) - 1, 1},
+		{"crlf", strings.ReplaceAll(good+from+"Subject: two\n\ntwo\n", "\n", "\r\n"), MboxRD, RepairClosed, 134, 2},
+		{"body line", good + from + "Subject: two\n\nFrom here on\n", MboxRD, RepairClosed, 134, 1},
+		{"incomplete line", good + from + "Subject: two\n\ntw", MboxRD, RepairTruncated, int64(len(good)), 0},
+		{"incomplete envelope", good + "From a@exa", MboxRD, RepairTruncated, int64(len(good)), 0},
+		{"only envelope", good + from, MboxRD, RepairTruncated, int64(len(good)), 0},
+		{"content length", good + from + "Content-Length: 10\n\nshort\n", MboxCL2, RepairTruncated, int64(len(good)), 0},
+		{"long", good + long[:len(long)-2], MboxRD, RepairTruncated, int64(len(good)), 0},
+	}
+	for _, test := range tests {
+		rep, err := CheckTail(strings.NewReader(test.in), int64(len(test.in)), WithVariant(test.variant))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if rep.Action != test.action || rep.Offset != test.offset || rep.Added != test.added {
+			t.Errorf("%s: unexpected report %+v", test.name, rep)
+		}
+		if rep.Action == RepairTruncated && rep.Removed != int64(len(test.in))-test.offset {
+			t.Errorf("%s: unexpected removed bytes %d", test.name, rep.Removed)
+		}
+	}
+	if _, err := CheckTail(strings.NewReader("garbage\n"), 8); !errors.Is(err, InvalidFormat) {
+		t.Error("expecting InvalidFormat, got", err)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	const from = "From a@example.com Wed Jan 27 02:32:22 2021\n"
+	const good = from + "Subject: one\n\none\n\n"
+	path := filepath.Join(t.TempDir(), "mbox")
+	for _, test := range []struct {
+		in       string
+		expected string
+		report   string
+	}{
+		{good + from + "Subject: two\n\ntw", good, "truncated at offset 63, removing 60 bytes: the last line is incomplete"},
+		{good + from + "Subject: two\n\ntwo\n", good + from + "Subject: two\n\ntwo\n\n",
+			"closed at offset 125, adding 1 bytes: the blank line after the last message is missing"},
+		{good, good, "no repair needed"},
+	} {
+		if err := os.WriteFile(path, []byte(test.in), 0600); err != nil {
+			t.Fatal(err)
+		}
+		rep, err := Repair(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.String() != test.report {
+			t.Error("unexpected report", rep)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.expected {
+			t.Errorf("unexpected mailbox %q", data)
+		}
+		readBodies(t, string(data))
+	}
+}
@@ -0,0 +1,140 @@
+package mbox
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+// Message is a single message read by a Scanner
+type Message struct {
+	// Envelope is the "From " line of the message
+	Envelope Envelope
+	// Body reads the message, unescaped. It is only valid until the next call to Next
+	Body io.Reader
+}
+
+// messageReader is implemented by the decoder of each format.
+// Read returns io.EOF at the end of each message
+type messageReader interface {
+	io.Reader
+	// next advances to the next message, io.EOF is returned if there are no more messages
+	next() error
+	// Envelope returns the envelope of the current message
+	Envelope() (Envelope, error)
+}
+
+// Scanner reads messages from an mbox stream one at a time
+type Scanner struct {
+	d    messageReader
+	msg  *Message
+	body *body
+	buf  []byte
+	err  error
+
+	config
+}
+
+// body reads a single message from the decoder, it stops at the message boundary
+type body struct {
+	d   messageReader
+	eof bool
+}
+
+// Read implements io.Reader
+func (b *body) Read(p []byte) (int, error) {
+	if b.eof {
+		return 0, io.EOF
+	}
+	n, err := b.d.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// NewScanner returns a Scanner, ready to read messages from r.
+// Use WithVariant to read formats other than mboxrd, including MMDF and Babyl
+func NewScanner(r io.Reader, opts ...Option) *Scanner {
+	s := &Scanner{config: newConfig(opts)}
+	switch s.variant {
+	case MMDF:
+		s.d = NewMMDFReader(r, opts...)
+	case Babyl:
+		s.d = NewBabylReader(r, opts...)
+	default:
+		s.d = NewReader(r, opts...)
+	}
+	return s
+}
+
+// Next advances to the next message, which will be available with Message.
+// Any unread part of the current message is skipped.
+// It returns false when there are no more messages or an error occurred.
+func (s *Scanner) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	if s.body != nil {
+		// skip the remainder of the current message
+		if !s.body.eof {
+			if s.buf == nil {
+				s.buf = make([]byte, defaultBufferSize)
+			}
+			if _, err := io.CopyBuffer(io.Discard, s.body, s.buf); err != nil {
+				s.err = err
+				return false
+			}
+		}
+		s.body.eof = true // invalidate the old body
+		s.body = nil
+		s.msg = nil
+	}
+	if err := s.d.next(); err != nil {
+		if err != io.EOF {
+			s.err = err
+		}
+		return false
+	}
+	e, err := s.d.Envelope()
+	if err != nil {
+		var perr *ParseError
+		if !s.recovery || !errors.As(err, &perr) {
+			s.err = err
+			return false
+		}
+		// keep the message, with what could be parsed of the envelope
+		s.reportError(perr)
+	}
+	s.body = &body{d: s.d}
+	s.msg = &Message{
+		Envelope: e,
+		Body:     s.body,
+	}
+	return true
+}
+
+// Message returns the current message
+func (s *Scanner) Message() *Message {
+	return s.msg
+}
+
+// Err returns the first error that stopped the Scanner, nil if it stopped at the end of the stream
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// All returns an iterator over the remaining messages.
+// If an error stops the iteration, it is yielded with a nil message as the last value.
+func (s *Scanner) All() iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		for s.Next() {
+			if !yield(s.msg, nil) {
+				return
+			}
+		}
+		if s.err != nil {
+			yield(nil, s.err)
+		}
+	}
+}
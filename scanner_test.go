@@ -0,0 +1,145 @@
+package mbox
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// three messages, the last one without a body
+const scanTest1 = `From test@example.com Wed Jan 27 02:32:22 2021
+>>From this should be unescaped
+12345678
+
+From test2@example.com Wed Jan 27 02:32:23 2021
+Subject: second
+
+Hello
+
+From test3@example.com Wed Jan 27 02:32:24 2021
+
+`
+
+func TestScannerNext(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte(scanTest1)))
+	var bodies []string
+	var froms []string
+	var offsets []int64
+	for s.Next() {
+		m := s.Message()
+		b, err := io.ReadAll(m.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, string(b))
+		froms = append(froms, m.Envelope.From)
+		offsets = append(offsets, m.Envelope.Offset)
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	if len(bodies) != 3 {
+		t.Fatal("expecting 3 messages, got", len(bodies))
+	}
+	if bodies[0] != ">From this should be unescaped\n12345678\n" {
+		t.Error("unexpected body", bodies[0])
+	}
+	if bodies[1] != "Subject: second\n\nHello\n" {
+		t.Error("unexpected body", bodies[1])
+	}
+	if bodies[2] != "" {
+		t.Error("expecting an empty body", bodies[2])
+	}
+	if froms[2] != "test3@example.com" {
+		t.Error("expecting test3@example.com")
+	}
+	if offsets[0] != 0 || offsets[1] != 89 || offsets[2] != 161 {
+		t.Error("unexpected offsets", offsets)
+	}
+}
+
+// the bodies are not read, Next should skip them
+func TestScannerSkip(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte(scanTest1)))
+	count := 0
+	for s.Next() {
+		count++
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	if count != 3 {
+		t.Error("expecting 3 messages, got", count)
+	}
+}
+
+func TestScannerAll(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte(scanTest1)))
+	count := 0
+	for m, err := range s.All() {
+		if err != nil {
+			t.Error(err)
+			break
+		}
+		if m.Envelope.Date.Unix() != int64(1611714742+count) {
+			t.Error("invalid date")
+		}
+		count++
+	}
+	if count != 3 {
+		t.Error("expecting 3 messages, got", count)
+	}
+}
+
+func TestScannerError(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte(readTest2)))
+	if !s.Next() {
+		t.Fatal("expecting a message")
+	}
+	if _, err := io.ReadAll(s.Message().Body); !errors.Is(err, InvalidFormat) {
+		t.Error("InvalidFormat expected")
+	}
+	if s.Next() {
+		t.Error("not expecting a message")
+	}
+	if !errors.Is(s.Err(), InvalidFormat) {
+		t.Error("InvalidFormat expected")
+	}
+
+	s = NewScanner(bytes.NewReader([]byte(readTest7)))
+	var err error
+	for _, err = range s.All() {
+	}
+	if !errors.Is(err, InvalidFormat) {
+		t.Error("InvalidFormat expected")
+	}
+}
+
+// the first message ends with a blank line, followed by the separator
+const scanTest2 = `From test@example.com Wed Jan 27 02:32:22 2021
+body
+
+
+From test2@example.com Wed Jan 27 02:32:23 2021
+body2
+
+`
+
+func TestScannerBlankLines(t *testing.T) {
+	s := NewScanner(bytes.NewReader([]byte(scanTest2)))
+	var bodies []string
+	for s.Next() {
+		b, err := io.ReadAll(s.Message().Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, string(b))
+	}
+	if len(bodies) != 2 {
+		t.Fatal("expecting 2 messages, got", len(bodies))
+	}
+	if bodies[0] != "body\n\n" {
+		t.Error("unexpected body", bodies[0])
+	}
+}
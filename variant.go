@@ -0,0 +1,44 @@
+package mbox
+
+// Variant is a variation of the mbox format
+type Variant int
+
+// possible values for Variant
+const (
+	// MboxRD escapes "From " lines by adding a ">" to lines matching >*"From ", and removes one when reading.
+	// It's the default
+	MboxRD Variant = iota
+	// MboxO escapes "From " lines by adding a ">", but lines that are already escaped are not touched,
+	// and nothing is unescaped when reading
+	MboxO
+	// MboxCL escapes like MboxO, and adds a Content-Length header which the reader uses to find the end of the message
+	MboxCL
+	// MboxCL2 adds a Content-Length header which the reader uses to find the end of the message,
+	// nothing is escaped
+	MboxCL2
+	// MMDF encloses each message with lines of four Ctrl-A characters, there is no "From " line and nothing is
+	// escaped. See NewMMDFReader and NewMMDFWriter
+	MMDF
+	// Babyl is the format of Emacs RMAIL files. Each message has attributes and labels, and
+	// nothing is escaped. See NewBabylReader and NewBabylWriter
+	Babyl
+)
+
+// String returns the name of the variant
+func (v Variant) String() string {
+	switch v {
+	case MboxRD:
+		return "mboxrd"
+	case MboxO:
+		return "mboxo"
+	case MboxCL:
+		return "mboxcl"
+	case MboxCL2:
+		return "mboxcl2"
+	case MMDF:
+		return "mmdf"
+	case Babyl:
+		return "babyl"
+	}
+	return "unknown"
+}
@@ -0,0 +1,294 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testO = `testO
+From null@example.com
+>From null@example.com
+>>From null@example.com
+`
+
+const testOExpected = `testO
+>From null@example.com
+>From null@example.com
+>>From null@example.com
+`
+
+func TestWriteMboxO(t *testing.T) {
+	b := bytes.Buffer{}
+	w := NewWriter(&b, WithVariant(MboxO))
+	err := w.Open("test@example.com", time.Now())
+	if err != nil {
+		t.Error(err)
+	}
+	n, err := w.Write([]byte(testO))
+	if err != nil {
+		t.Error(err)
+	}
+	if n != len(testO) {
+		t.Error("expecting", len(testO), "bytes, got", n)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	result := b.String()
+	result = result[strings.Index(result, "\n")+1:] // cut the header off
+	if result != testOExpected+"\n" {
+		t.Error("did not get testOExpected", result)
+	}
+}
+
+func TestReadMboxO(t *testing.T) {
+	const in = "From test@example.com Wed Jan 27 02:32:22 2021\n" + testOExpected + "\n"
+	buf := make([]byte, 8)
+	var b bytes.Buffer
+	r := NewReader(bytes.NewReader([]byte(in)), WithVariant(MboxO))
+	_, err := io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		t.Error(err)
+	}
+	if b.String() != testOExpected {
+		t.Error("expecting the message not to be unescaped", b.String())
+	}
+
+	// mboxrd would unescape
+	b.Reset()
+	r = NewReader(bytes.NewReader([]byte(in)))
+	_, err = io.CopyBuffer(struct{ io.Writer }{&b}, struct{ io.Reader }{r}, buf)
+	if err != nil {
+		t.Error(err)
+	}
+	if b.String() != "testO\nFrom null@example.com\nFrom null@example.com\n>From null@example.com\n" {
+		t.Error("expecting the message to be unescaped", b.String())
+	}
+}
+
+// a message that ends with a partial "From " should be written out
+func TestWritePartialFrom(t *testing.T) {
+	for _, v := range []Variant{MboxRD, MboxO} {
+		b := bytes.Buffer{}
+		w := NewWriter(&b, WithVariant(v))
+		_ = w.Open("test@example.com", time.Now())
+		_, _ = w.Write([]byte("test\n>>Fro"))
+		_ = w.Close()
+		result := b.String()
+		result = result[strings.Index(result, "\n")+1:] // cut the header off
+		if result != "test\n>>Fro\n" {
+			t.Error(v, "unexpected result", result)
+		}
+	}
+}
+
+// a line with a partial "From" must not stop the next line from being escaped
+func TestWritePartialFromLine(t *testing.T) {
+	bodies := []string{
+		"Fro\nFrom y\n",
+		"Fro\n>From x\n",
+		">Fro\nFrom y\n",
+		"Fr\n>>From x\nFrom y\n",
+		"Fro\r\nFrom y\r\n",
+	}
+	for _, v := range []Variant{MboxRD, MboxO} {
+		for _, body := range bodies {
+			expected := body
+			if v == MboxO {
+				// mboxo does not unescape
+				expected = strings.ReplaceAll(body, "\nFrom ", "\n>From ")
+			}
+			b := bytes.Buffer{}
+			w := NewWriter(&b, WithVariant(v))
+			_ = w.Open("test@example.com", time.Now())
+			_, _ = w.Write([]byte(body))
+			_ = w.Close()
+			var out bytes.Buffer
+			r := NewReader(bytes.NewReader(b.Bytes()), WithVariant(v))
+			if _, err := io.Copy(&out, r); err != nil {
+				t.Error(v, err)
+			}
+			if out.String() != expected {
+				t.Errorf("%s: expecting %q, got %q from %q", v, expected, out.String(), b.String())
+			}
+		}
+	}
+}
+
+func TestVariantString(t *testing.T) {
+	if MboxRD.String() != "mboxrd" || MboxO.String() != "mboxo" {
+		t.Error("unexpected variant names")
+	}
+}
+
+// the body has a "From " line after a blank line, which only the Content-Length can tell apart
+const testCL = `Subject: test
+Content-Length: 999
+
+body
+
+From null@example.com
+>From null@example.com
+`
+
+func TestWriteMboxCL(t *testing.T) {
+	tests := []struct {
+		v        Variant
+		expected string
+	}{
+		{MboxCL, "Subject: test\nContent-Length: 52\n\nbody\n\n>From null@example.com\n>From null@example.com\n\n"},
+		{MboxCL2, "Subject: test\nContent-Length: 51\n\nbody\n\nFrom null@example.com\n>From null@example.com\n\n"},
+	}
+	for _, test := range tests {
+		b := bytes.Buffer{}
+		w := NewWriter(&b, WithVariant(test.v))
+		_ = w.Open("test@example.com", time.Now())
+		buf := make([]byte, 8)
+		n, err := io.CopyBuffer(struct{ io.Writer }{w}, struct{ io.Reader }{bytes.NewReader([]byte(testCL))}, buf)
+		if err != nil {
+			t.Error(err)
+		}
+		if n != int64(len(testCL)) {
+			t.Error("unexpected length", n)
+		}
+		if err = w.Close(); err != nil {
+			t.Error(err)
+		}
+		result := b.String()
+		if !strings.HasPrefix(result, "From test@example.com ") {
+			t.Error("expecting the From line")
+		}
+		result = result[strings.Index(result, "\n")+1:] // cut the header off
+		if result != test.expected {
+			t.Error(test.v, "unexpected result", result)
+		}
+	}
+}
+
+// a CRLF message written with LineEndingKeep gets its Content-Length header with "\r\n"
+func TestWriteMboxCLCRLF(t *testing.T) {
+	tests := []struct {
+		in, expected string
+	}{
+		{"Subject: t\r\n\r\nFr\r\n", "Subject: t\r\nContent-Length: 4\r\n\r\nFr\r\n\n"},
+		{"\r\nFr\r\n", "Content-Length: 4\r\n\r\nFr\r\n\n"},
+	}
+	for _, v := range []Variant{MboxCL, MboxCL2} {
+		for _, test := range tests {
+			var b bytes.Buffer
+			w := NewMailboxWriter(&b, WithVariant(v))
+			if err := w.BeginMessage(Envelope{From: "a@example.com", Date: time.Unix(1611714742, 0)}); err != nil {
+				t.Fatal(err)
+			}
+			_, _ = w.Write([]byte(test.in))
+			if err := w.Close(); err != nil {
+				t.Error(err)
+			}
+			result := b.String()
+			if result = result[strings.Index(result, "\n")+1:]; result != test.expected {
+				t.Errorf("%s: unexpected result %q", v, result)
+			}
+			// the Content-Length header is read as part of the message
+			body := strings.TrimSuffix(test.expected, "\n")
+			if bodies := readBodies(t, b.String(), WithVariant(v)); !slices.Equal(bodies, []string{body}) {
+				t.Errorf("%s: unexpected bodies %q", v, bodies)
+			}
+		}
+	}
+}
+
+func TestReadMboxCL(t *testing.T) {
+	for _, v := range []Variant{MboxCL, MboxCL2} {
+		var b bytes.Buffer
+		w := NewWriter(struct{ io.Writer }{&b}, WithVariant(v))
+		_ = w.Open("test@example.com", time.Now())
+		_, _ = w.Write([]byte(testCL))
+		_ = w.Close()
+		// an empty message
+		_ = w.Open("test2@example.com", time.Now())
+		_ = w.Close()
+		_ = w.Open("test3@example.com", time.Now())
+		_, _ = w.Write([]byte("Subject: last\n\nno newline"))
+		_ = w.Close()
+
+		s := NewScanner(&b, WithVariant(v))
+		var bodies []string
+		for s.Next() {
+			body, err := io.ReadAll(s.Message().Body)
+			if err != nil {
+				t.Error(err)
+			}
+			bodies = append(bodies, string(body))
+		}
+		if s.Err() != nil {
+			t.Error(v, s.Err())
+		}
+		if len(bodies) != 3 {
+			t.Fatal(v, "expecting 3 messages, got", len(bodies))
+		}
+		if !strings.Contains(bodies[0], "body\n\n") || !strings.HasSuffix(bodies[0], "From null@example.com\n") {
+			t.Error(v, "unexpected body", bodies[0])
+		}
+		if bodies[1] != "Content-Length: 0\n\n" {
+			t.Error(v, "unexpected body", bodies[1])
+		}
+		if bodies[2] != "Subject: last\nContent-Length: 10\n\nno newline" {
+			t.Error(v, "unexpected body", bodies[2])
+		}
+	}
+}
+
+// without a Content-Length, or with a wrong one, "From " lines are used
+func TestReadMboxCLFallback(t *testing.T) {
+	const in = `From test@example.com Wed Jan 27 02:32:22 2021
+Subject: no length
+
+body
+
+From test@example.com Wed Jan 27 02:32:22 2021
+Content-Length: 2
+
+body
+
+From test@example.com Wed Jan 27 02:32:22 2021
+
+`
+	s := NewScanner(bytes.NewReader([]byte(in)), WithVariant(MboxCL2))
+	var bodies []string
+	for s.Next() {
+		body, err := io.ReadAll(s.Message().Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies = append(bodies, string(body))
+	}
+	if s.Err() != nil {
+		t.Error(s.Err())
+	}
+	if len(bodies) != 3 {
+		t.Fatal("expecting 3 messages, got", len(bodies))
+	}
+	if bodies[0] != "Subject: no length\n\nbody\n" || bodies[1] != "Content-Length: 2\n\nbody\n" || bodies[2] != "" {
+		t.Error("unexpected bodies", bodies)
+	}
+}
+
+// a message without a Content-Length and without a body must not be merged into the next one
+func TestReadMboxCLNoBody(t *testing.T) {
+	const from = "From a@b Thu Jan  1 00:00:00 1970"
+	in := from + "\nSubject: x\n\n" + from + "\nSubject: y\n\nbody\n\n"
+	for _, v := range []Variant{MboxRD, MboxCL, MboxCL2} {
+		for _, le := range []string{"\n", "\r\n"} {
+			bodies := readBodies(t, strings.ReplaceAll(in, "\n", le), WithVariant(v))
+			expected := []string{"Subject: x" + le, strings.ReplaceAll("Subject: y\n\nbody\n", "\n", le)}
+			if !slices.Equal(bodies, expected) {
+				t.Errorf("%s: unexpected bodies %q", v, bodies)
+			}
+		}
+	}
+}
@@ -1,4 +1,4 @@
-// mbox implements an mbox writer and reader, using the mboxrd variation
+// mbox implements an mbox writer and reader, using the mboxrd variation by default (see Variant)
 // The implementations are stream-based and use minimal buffering
 //
 // --- Improved mbox
@@ -16,6 +16,7 @@ package mbox
 import (
 	"bytes"
 	"io"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,16 +35,24 @@ func init() {
 }
 
 type encoder struct {
+	// w counts the bytes written to the underlying writer c.w
 	w     io.Writer
+	c     counter
 	state writeState
 	n     int
-	// from is the from header
-	from          string
-	date          string
+	// env is the envelope of the current message
+	env           Envelope
 	pos           int
 	stuffingCount int
 	matches       int
 	sb            strings.Builder
+	// buf holds the message when the Content-Length needs to be known (mboxcl, mboxcl2)
+	buf bytes.Buffer
+	// lf holds the input converted to "\n" line endings, and cr is true if a "\r" was held back at its end
+	lf []byte
+	cr bool
+
+	config
 }
 
 type writeState int
@@ -63,6 +72,52 @@ const newLine = '\n'
 // eol is the end of line byte sequence
 var eol = []byte{newLine}
 
+// counter is an io.Writer that counts the bytes written to w
+type counter struct {
+	w io.Writer
+	n int64
+	// crlf converts "\n" to "\r\n", cr is true if the last byte written was a "\r"
+	crlf bool
+	cr   bool
+	buf  []byte
+}
+
+// Write implements io.Writer
+func (c *counter) Write(p []byte) (int, error) {
+	if c.crlf {
+		return c.writeCRLF(p)
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeCRLF writes p with "\n" converted to "\r\n", and returns how much of p was written
+func (c *counter) writeCRLF(p []byte) (int, error) {
+	cr := c.cr
+	c.buf = toCRLF(c.buf[:0], p, &c.cr)
+	n, err := c.w.Write(c.buf)
+	c.n += int64(n)
+	if err == nil {
+		return len(p), nil
+	}
+	// count the bytes of p that were written completely
+	i, out := 0, 0
+	for ; i < len(p); i++ {
+		size := 1
+		if p[i] == newLine && !cr {
+			size++
+		}
+		if out+size > n {
+			break
+		}
+		out += size
+		cr = p[i] == carriageReturn
+	}
+	c.cr = cr
+	return i, err
+}
+
 // writeByte writes a single byte to the underlying writer
 func (w *encoder) writeByte(b byte) (n int, err error) {
 	for {
@@ -74,7 +129,21 @@ func (w *encoder) writeByte(b byte) (n int, err error) {
 	return
 }
 
+// Write implements io.Writer, p is a part of the message that was opened with OpenEnvelope
 func (w *encoder) Write(p []byte) (int, error) {
+	if w.lineEnding == LineEndingKeep {
+		return w.write(p)
+	}
+	// the message is written with "\n", which the counter converts for LineEndingCRLF
+	w.lf = toLF(w.lf[:0], p, &w.cr, false)
+	if _, err := w.write(w.lf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// write encodes p, with the line endings as they are
+func (w *encoder) write(p []byte) (int, error) {
 	w.n = 0
 	var (
 		n   int
@@ -95,7 +164,7 @@ func (w *encoder) Write(p []byte) (int, error) {
 		case writeStateStartLine:
 			// only in this state if we're
 			// on the start of a new line / start of message.
-			if p[w.pos] == escape {
+			if p[w.pos] == escape && w.variant == MboxRD {
 				// keep counting how many >
 				w.stuffingCount = 1
 				// we don't write it out yet, but move on to next & let caller know we got it
@@ -103,7 +172,7 @@ func (w *encoder) Write(p []byte) (int, error) {
 				w.pos++
 				w.state = writeStateMatchStuffing
 				continue
-			} else if p[w.pos] == header[0] {
+			} else if p[w.pos] == header[0] && w.variant != MboxCL2 {
 				// match "From "
 				w.matches = 1
 				w.n++ // we don't write it out yet, but move on to next & let caller know we got it
@@ -131,9 +200,10 @@ func (w *encoder) Write(p []byte) (int, error) {
 			if p[w.pos] == escape {
 				w.stuffingCount++
 				w.pos++
+				w.n++
 				continue
 			}
-			// write out the stuffing
+			// write out the stuffing (already counted in w.n)
 			for w.stuffingCount > 0 {
 				toCopy := w.stuffingCount
 				if toCopy > spSize {
@@ -141,7 +211,6 @@ func (w *encoder) Write(p []byte) (int, error) {
 				}
 				n64, err = io.Copy(w.w, bytes.NewReader(escapePool[0:toCopy]))
 				w.stuffingCount -= int(n64)
-				w.n += int(n64)
 				if err != nil {
 					return w.n, err
 				}
@@ -152,6 +221,7 @@ func (w *encoder) Write(p []byte) (int, error) {
 			}
 			if p[w.pos] == header[0] {
 				w.pos++
+				w.n++
 				// match the start of the header
 				w.matches = 1
 				w.state = writeStateMatchFrom
@@ -189,44 +259,108 @@ func (w *encoder) Write(p []byte) (int, error) {
 			if err != nil {
 				return w.n, err
 			}
-			w.pos++
 			w.matches = 0
 			w.state = writeStateCopy
+			if p[w.pos] == newLine {
+				// the partial match was a whole line, the next line may need escaping
+				w.state = writeStateStartLine
+			}
+			w.pos++
 		}
 	}
 	return w.n, nil
 }
 
-func NewWriter(w io.Writer) *encoder {
+// NewWriter returns an io.Writer, ready to encode messages to mbox streams
+func NewWriter(w io.Writer, opts ...Option) *encoder {
 	e := new(encoder)
-	e.w = w
+	e.c.w = w
+	e.w = &e.c
+	e.config = newConfig(opts)
+	e.c.crlf = e.lineEnding == LineEndingCRLF
 	return e
 }
 
+// messageWriter is implemented by the encoder of each format
+type messageWriter interface {
+	io.Writer
+	// OpenEnvelope begins a new message
+	OpenEnvelope(e Envelope) error
+	// Envelope returns the envelope of the current message
+	Envelope() Envelope
+	// Close ends the message
+	Close() error
+	// offset returns the number of bytes written to the underlying writer
+	offset() int64
+	// rollback abandons the current message, the underlying writer was truncated to n bytes
+	rollback(n int64)
+}
+
+// newMessageWriter returns the encoder for the variant given in opts
+func newMessageWriter(w io.Writer, opts ...Option) messageWriter {
+	switch newConfig(opts).variant {
+	case MMDF:
+		return NewMMDFWriter(w, opts...)
+	case Babyl:
+		return NewBabylWriter(w, opts...)
+	}
+	return NewWriter(w, opts...)
+}
+
+// Open begins a new message from the sender, delivered at time t
 func (w *encoder) Open(from string, t time.Time) error {
-	w.from = from
-	w.date = t.UTC().Format(time.ANSIC)
-	w.sb.WriteString(header)
-	w.sb.WriteString(w.from)
-	w.sb.WriteString(" ")
-	w.sb.WriteString(w.date)
+	return w.OpenEnvelope(Envelope{From: from, Date: t})
+}
+
+// OpenEnvelope begins a new message with the "From " line of e.
+// e.Raw is written as-is if it is set
+func (w *encoder) OpenEnvelope(e Envelope) error {
+	w.env = e
+	w.env.Raw = e.String()
+	w.env.Offset = w.c.n
+	w.sb.WriteString(w.env.Raw)
 	w.sb.WriteString(string(newLine))
+	if w.variant == MboxCL || w.variant == MboxCL2 {
+		// buffer the message until Close
+		w.w = &w.buf
+	}
 	return nil
 }
 
+// Envelope returns the envelope of the current message
+func (w *encoder) Envelope() Envelope {
+	return w.env
+}
+
+// Close ends the message, and closes the underlying writer if it's an io.Closer
 func (w *encoder) Close() error {
-	defer func() {
-		w.state = 0
-		w.matches = 0
-		w.stuffingCount = 0
-		w.sb.Reset()
-	}()
-	if w.matches == 5 {
+	defer w.reset()
+	if w.cr {
+		// a "\r" at the end of the message
+		w.cr = false
+		if _, err := w.write([]byte{carriageReturn}); err != nil {
+			return err
+		}
+	}
+	if w.state == writeStateHeader {
+		// nothing was written yet, the message is empty
+		_, err := io.Copy(w.w, strings.NewReader(w.sb.String()))
+		if err != nil {
+			return err
+		}
+	}
+	if w.matches == len(header) {
 		// edge case
 		_, err := io.Copy(w.w, bytes.NewReader([]byte(headerEscaped)))
 		if err != nil {
 			return err
 		}
+	} else if w.matches > 0 {
+		// ended with a partial match, write it out as-is
+		_, err := io.Copy(w.w, bytes.NewReader([]byte(header[:w.matches])))
+		if err != nil {
+			return err
+		}
 	} else if w.stuffingCount > 0 {
 		// another edge case
 		for w.stuffingCount > 0 {
@@ -236,15 +370,100 @@ func (w *encoder) Close() error {
 			}
 			n64, err := io.Copy(w.w, bytes.NewReader(escapePool[0:toCopy]))
 			w.stuffingCount -= int(n64)
-			w.n += int(n64)
 			if err != nil {
 				return err
 			}
 		}
 	}
+	if w.variant == MboxCL || w.variant == MboxCL2 {
+		w.w = &w.c
+		if err := w.writeContentLength(); err != nil {
+			return err
+		}
+	}
 	_, err := w.writeByte(newLine)
-	if closer, ok := w.w.(io.Closer); ok {
+	if closer, ok := w.c.w.(io.Closer); ok {
 		return closer.Close()
 	}
 	return err
 }
+
+// reset clears the state of the current message
+func (w *encoder) reset() {
+	w.state = 0
+	w.matches = 0
+	w.stuffingCount = 0
+	w.sb.Reset()
+	w.buf.Reset()
+	w.w = &w.c
+	w.cr = false
+}
+
+// offset returns the number of bytes written to the underlying writer
+func (w *encoder) offset() int64 {
+	return w.c.n
+}
+
+// rollback abandons the current message, the underlying writer was truncated to n bytes
+func (w *encoder) rollback(n int64) {
+	w.reset()
+	w.c.n = n
+	w.c.cr = false
+}
+
+// writeContentLength writes out the buffered message, with a Content-Length header added to the end of the
+// headers. Any previous Content-Length header is removed
+func (w *encoder) writeContentLength() error {
+	msg := w.buf.Bytes()
+	// the "From " line
+	i := bytes.IndexByte(msg, newLine) + 1
+	if _, err := io.Copy(w.w, bytes.NewReader(msg[:i])); err != nil {
+		return err
+	}
+	msg = msg[i:]
+	var headers, body []byte
+	// le is the line ending of the headers, the Content-Length header is written with it
+	le := "\n"
+	if blankLine(msg) {
+		if msg[0] == carriageReturn {
+			le = "\r\n"
+		}
+		body = msg[len(le):]
+	} else if h, b := headerEnd(msg); h != -1 {
+		headers, body = msg[:h+1], msg[b:]
+	} else {
+		headers = msg
+	}
+	if i = bytes.IndexByte(headers, newLine); i > 0 && headers[i-1] == carriageReturn {
+		le = "\r\n"
+	}
+	for len(headers) > 0 {
+		line := headers
+		if i = bytes.IndexByte(headers, newLine); i != -1 {
+			line = headers[:i+1]
+		}
+		headers = headers[len(line):]
+		if isField(line, contentLengthField) {
+			continue
+		}
+		if _, err := io.Copy(w.w, bytes.NewReader(line)); err != nil {
+			return err
+		}
+		if line[len(line)-1] != newLine {
+			if _, err := io.WriteString(w.w, le); err != nil {
+				return err
+			}
+		}
+	}
+	length := len(body)
+	if w.lineEnding == LineEndingCRLF {
+		// the counter adds a "\r" to each line
+		length += bytes.Count(body, eol)
+	}
+	cl := contentLengthField + " " + strconv.Itoa(length) + le + le
+	if _, err := io.Copy(w.w, strings.NewReader(cl)); err != nil {
+		return err
+	}
+	_, err := io.Copy(w.w, bytes.NewReader(body))
+	return err
+}
@@ -316,13 +316,35 @@ func TestDataPrematureEscaped3(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	if n != 24 {
-		t.Error("Expecting 24 bytes")
+	if n != 39 {
+		t.Error("Expecting 39 bytes")
 	}
 	w.Close()
 	//fmt.Println(b.String(), n, err)
 }
 
+// a partial "From" at the end is written out by Close
+func TestDataPartialFrom(t *testing.T) {
+	b := bytes.Buffer{}
+	w := NewWriter(&b)
+	w.Open("test@example.com", time.Now())
+	n, err := w.Write([]byte("one\nFro"))
+	if err != nil {
+		t.Error(err)
+	}
+	if n != 7 {
+		t.Error("Expecting 7 bytes")
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+	}
+	result := b.String()
+	result = result[strings.Index(result, "\n")+1:] // cut the header off
+	if !strings.HasPrefix(result, "one\nFro") {
+		t.Errorf("unexpected result %q", result)
+	}
+}
+
 // // 33 > chars (toCopy > 32)
 func TestDataEEscapeOverflow(t *testing.T) {
 	b := bytes.Buffer{}